@@ -5,11 +5,20 @@ go 1.25.0
 require (
 	github.com/a-h/templ v0.3.1020
 	github.com/alecthomas/chroma v0.10.0
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/dop251/goja v0.0.0-20260106131823-651366fbe6e3
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/tdewolff/minify/v2 v2.21.1
+	github.com/tdewolff/parse/v2 v2.7.18
 	github.com/yuin/goldmark v1.7.8
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.51.0
 )
 
 require (
 	github.com/dlclark/regexp2 v1.11.5 // indirect
-	github.com/tdewolff/parse/v2 v2.7.18 // indirect
+	github.com/go-sourcemap/sourcemap v2.1.3+incompatible // indirect
+	github.com/google/pprof v0.0.0-20230207041349-798e818bf904 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
 )
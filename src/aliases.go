@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/a-h/templ"
+)
+
+// aliasFilename returns the output file of the redirect stub left at
+// alias: "/old.html" is written to old.html, "/posts/old" to
+// posts/old.html, and "/posts/old/" to posts/old/index.html.
+func aliasFilename(alias string) string {
+	filename := strings.TrimPrefix(alias, "/")
+	switch {
+	case filename == "" || strings.HasSuffix(filename, "/"):
+		return filename + "index.html"
+	case path.Ext(filename) == "":
+		return filename + ".html"
+	}
+	return filename
+}
+
+// checkArticleAliases reports aliases that are not static site paths, and
+// aliases whose redirect stub would replace the page of an article or the
+// stub of another alias.
+func checkArticleAliases(config Config, articles []Article, diags *Diagnostics) {
+	owners := make(map[string]string)
+	for _, a := range articles {
+		owners[config.pageFilename(a.HTMLFilename)] = "the page of " + a.ManifestFilename
+	}
+
+	for _, a := range articles {
+		pos := manifestKeyPosition(filepath.Join(config.ArticleDir, a.ManifestFilename), "aliases")
+		for _, alias := range a.Manifest.Aliases {
+			switch {
+			case !strings.HasPrefix(alias, "/"):
+				diags.Errorf(pos, "alias %q must start with /", alias)
+				continue
+			case strings.ContainsAny(alias, "*:?# \t\r\n"):
+				diags.Errorf(pos, "alias %q must be a static path, without splat, placeholder, query or fragment", alias)
+				continue
+			case path.Clean(alias) != strings.TrimSuffix(alias, "/") && alias != "/":
+				diags.Errorf(pos, "alias %q must be a clean path", alias)
+				continue
+			}
+
+			filename := aliasFilename(alias)
+			if owner, exists := owners[filename]; exists {
+				diags.Errorf(pos, "alias %s collides with %s, written to %s", alias, owner, filename)
+				continue
+			}
+			owners[filename] = "alias " + alias + " of " + a.ManifestFilename
+		}
+	}
+}
+
+// aliasPages generates a redirect stub for every alias of every article,
+// so that links to its former paths keep working on any host. On
+// Cloudflare Pages, _redirects answers them with a 301 first.
+type aliasPages struct{}
+
+func (aliasPages) Name() string { return "alias redirects" }
+
+func (aliasPages) PostProcess(site *Site, out *outputWriter) error { return nil }
+
+func (aliasPages) Pages(site *Site) []Page {
+	var pages []Page
+	for _, a := range site.Articles {
+		title := a.Manifest.Title
+		target := site.Config.pageURL(a.HTMLFilename)
+		canonicalURL := site.Config.BaseURL + target
+		for _, alias := range a.Manifest.Aliases {
+			pages = append(pages, Page{
+				Filename: aliasFilename(alias),
+				Inputs:   []string{title, target, canonicalURL},
+				Render: func(styleTags, scriptTags []string) templ.Component {
+					return redirectStub(title, target, canonicalURL)
+				},
+			})
+		}
+	}
+	return pages
+}
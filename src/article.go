@@ -6,7 +6,6 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
 	"time"
 )
 
@@ -18,10 +17,14 @@ type ArticleManifest struct {
 	Tags         []string `json:"tags,omitempty"`
 	MarkdownFile string   `json:"markdownFile,omitempty"`
 	CssFile      string   `json:"cssFile,omitempty"`
-	ScriptFile   string   `json:"scriptFile"`
+	ScriptFile   string   `json:"scriptFile,omitempty"`
 	Description  string   `json:"description"`
 	Author       string   `json:"author"`
 	AuthorImage  string   `json:"authorImage"`
+
+	// Aliases are former paths of the article, such as "/old-title.html",
+	// redirected to it.
+	Aliases []string `json:"aliases,omitempty"`
 }
 
 // TOCEntry represents a single entry in the table of contents,
@@ -33,15 +36,20 @@ type TOCEntry struct {
 }
 
 // Article represents a fully parsed blog article with its HTML content,
-// metadata, and table of contents.
+// metadata, table of contents, and the external links and local images it
+// references. Bundle is the directory of an article bundle, relative to the
+// article directory, or "" for a flat article.
 type Article struct {
 	ManifestFilename string
+	Bundle           string
 	HTMLFilename     string
 	StringifiedHTML  string
 	Date             time.Time
 	FormattedDate    string
 	Manifest         *ArticleManifest
 	TOC              []TOCEntry
+	ExternalLinks    []ExternalLink
+	Images           []ArticleImage
 }
 
 // getDateSuffix returns the English ordinal suffix for a day number
@@ -72,7 +80,8 @@ func formatDate(date time.Time) string {
 }
 
 // readArticleManifest reads and unmarshals a JSON manifest file into
-// an ArticleManifest.
+// an ArticleManifest. Malformed JSON is reported as a Diagnostic pointing
+// at the offending line and column.
 func readArticleManifest(filename string) (*ArticleManifest, error) {
 	var manifest ArticleManifest
 	content, err := os.ReadFile(filename)
@@ -81,57 +90,183 @@ func readArticleManifest(filename string) (*ArticleManifest, error) {
 	}
 	err = json.Unmarshal(content, &manifest)
 	if err != nil {
-		return nil, err
+		return nil, Diagnostic{
+			Position: jsonErrorPosition(filename, content, err),
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("invalid manifest: %v", err),
+		}
 	}
 	return &manifest, nil
 }
 
-// parseArticles reads all JSON manifests from articleDir, parses their
-// corresponding markdown files, and returns the articles sorted by date
-// descending. Draft articles are excluded unless env is "development".
-func parseArticles(articleDir, env string) ([]Article, error) {
-	files, err := os.ReadDir(articleDir)
+// manifestKeyPosition returns the position of key in the manifest file,
+// falling back to the file itself when it cannot be located.
+func manifestKeyPosition(filename, key string) Position {
+	content, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("error while opening directory '%s': '%w'", articleDir, err)
+		return Position{File: filename}
 	}
-	articles := make([]Article, 0)
-	for _, file := range files {
-		filename := file.Name()
-		if strings.HasSuffix(filename, ".json") {
-			manifestFilename := filename
-			manifestFullPath := filepath.Join(articleDir, manifestFilename)
-			manifest, err := readArticleManifest(manifestFullPath)
-			if err != nil {
-				return nil, err
-			}
-			if manifest.Draft && env != "development" {
-				continue
-			}
-			htmlFilename := strings.TrimSuffix(manifest.MarkdownFile, ".md") + ".html"
-			date, err := time.Parse(time.DateOnly, manifest.Date)
-			if err != nil {
-				return nil, err
-			}
-			markdownFullPath := filepath.Join(articleDir, manifest.MarkdownFile)
-			formattedDate := formatDate(date)
-			stringifiedHTML, toc, err := parseArticleMarkdown(markdownFullPath, formattedDate, manifest.Author, manifest.AuthorImage)
-			if err != nil {
-				return nil, err
-			}
-			article := Article{
-				ManifestFilename: manifestFilename,
-				HTMLFilename:     htmlFilename,
-				Date:             date,
-				FormattedDate:    formattedDate,
-				Manifest:         manifest,
-				StringifiedHTML:  stringifiedHTML,
-				TOC:              toc,
-			}
-			articles = append(articles, article)
+	return jsonKeyPosition(filename, content, key)
+}
+
+// parseArticle reads a single JSON manifest from config.ArticleDir, that of
+// a flat article or of a bundle, and parses its corresponding markdown
+// file. Every problem found is recorded in diags, and a nil Article is
+// returned when there was at least one error. A nil Article is also
+// returned when the article is a draft and config.Env is not "development".
+//
+// With config.SkipBroken, the errors of a broken draft are downgraded to
+// warnings and the draft is left out of the build.
+func parseArticle(config Config, manifestFilename string, cache *buildCache, diags *Diagnostics) *Article {
+	manifestFullPath := filepath.Join(config.ArticleDir, manifestFilename)
+	manifest, err := loadArticleManifest(config.ArticleDir, manifestFilename)
+	if err != nil {
+		diags.AddError(manifestFullPath, err)
+		return nil
+	}
+	if manifest.Draft && config.Env != "development" {
+		return nil
+	}
+
+	// Problems are collected locally first, so that those of a draft can
+	// be downgraded before being reported.
+	var local Diagnostics
+	article := parseArticleSources(config, manifestFilename, manifest, cache, &local)
+
+	skip := local.HasErrors() && manifest.Draft && config.SkipBroken
+	for _, d := range local.Items() {
+		if skip {
+			d.Severity = SeverityWarning
+		}
+		diags.Add(d)
+	}
+	if skip {
+		diags.Warnf(Position{File: manifestFullPath}, "skipping broken draft")
+	}
+	if local.HasErrors() {
+		return nil
+	}
+	return article
+}
+
+// parseArticleSources validates manifest and parses its markdown file,
+// recording problems in diags. Articles whose manifest, markdown and images
+// are unchanged since the previous build are served from cache, along with
+// their warnings, instead of being parsed again.
+func parseArticleSources(config Config, manifestFilename string, manifest *ArticleManifest, cache *buildCache, diags *Diagnostics) *Article {
+	manifestFullPath := filepath.Join(config.ArticleDir, manifestFilename)
+
+	if manifest.Title == "" {
+		diags.Errorf(manifestKeyPosition(manifestFullPath, "title"), "missing title")
+	}
+	if manifest.MarkdownFile == "" {
+		diags.Errorf(manifestKeyPosition(manifestFullPath, "markdownFile"), "missing markdownFile")
+		return nil
+	}
+	markdownFullPath := filepath.Join(config.ArticleDir, manifest.MarkdownFile)
+	hash, err := hashFiles(manifestFullPath, markdownFullPath)
+	if err != nil {
+		diags.Errorf(manifestKeyPosition(manifestFullPath, "markdownFile"), "cannot read markdown file: %v", err)
+		return nil
+	}
+	// The rendering of math depends on the version of KaTeX.
+	math, err := katexFor(config.SrcDir)
+	if err != nil {
+		diags.AddError(manifestFullPath, fmt.Errorf("cannot read KaTeX: %v", err))
+		return nil
+	}
+	hash = hashBytes([]byte(hash), []byte(math.hash))
+	if cached, warnings, ok := cache.article(manifestFilename, hash); ok && articleImagesUpToDate(cached.Images) {
+		for _, d := range warnings {
+			diags.Add(d)
 		}
+		return cached
 	}
-	sort.Slice(articles, func(i, j int) bool {
+
+	// Problems of this article alone, so that its warnings can be cached.
+	var local Diagnostics
+	bundle := articleBundle(manifestFilename)
+	date, err := time.Parse(time.DateOnly, manifest.Date)
+	if err != nil {
+		local.Errorf(manifestKeyPosition(manifestFullPath, "date"), "invalid date %q: expected YYYY-MM-DD", manifest.Date)
+	}
+	formattedDate := formatDate(date)
+	images := &imageResolver{
+		srcDir:     config.SrcDir,
+		articleDir: config.ArticleDir,
+		variantDir: filepath.Join(config.OutputDir, filepath.FromSlash(imageVariantCacheDir)),
+		bundle:     bundle,
+		filename:   markdownFullPath,
+		diags:      &local,
+	}
+	stringifiedHTML, toc, links, err := parseArticleMarkdown(markdownFullPath, bundle, formattedDate, manifest.Author, manifest.AuthorImage, images, math, &local)
+	if err != nil {
+		local.AddError(markdownFullPath, err)
+	}
+
+	items := local.Items()
+	for _, d := range items {
+		diags.Add(d)
+	}
+	if local.HasErrors() {
+		return nil
+	}
+
+	article := Article{
+		ManifestFilename: manifestFilename,
+		Bundle:           bundle,
+		HTMLFilename:     articleHTMLFilename(manifestFilename, manifest),
+		Date:             date,
+		FormattedDate:    formattedDate,
+		Manifest:         manifest,
+		StringifiedHTML:  stringifiedHTML,
+		TOC:              toc,
+		ExternalLinks:    links,
+		Images:           images.images,
+	}
+	cache.storeArticle(manifestFilename, hash, article, items)
+	return &article
+}
+
+// sortArticles orders articles by date descending, in place. Articles
+// sharing a date keep their relative order.
+func sortArticles(articles []Article) {
+	sort.SliceStable(articles, func(i, j int) bool {
 		return articles[i].Date.After(articles[j].Date)
 	})
+}
+
+// parseArticles reads all JSON manifests from config.ArticleDir and its
+// bundles, parses their corresponding markdown files, and returns the
+// articles sorted by date descending. Draft articles are excluded unless
+// config.Env is "development".
+//
+// A broken article does not stop the others from being parsed: every
+// problem is recorded in diags and the broken article is left out. Only
+// failing to list the directory is returned as an error. Aliases are
+// checked against every article once all of them are parsed.
+//
+// Articles are parsed concurrently by up to config.Workers goroutines; the
+// result is identical to a sequential parse.
+func parseArticles(config Config, cache *buildCache, diags *Diagnostics) ([]Article, error) {
+	manifestFilenames, err := listArticleManifests(config.ArticleDir)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := make([]*Article, len(manifestFilenames))
+	forEachParallel(len(manifestFilenames), config.Workers, func(i int) error {
+		parsed[i] = parseArticle(config, manifestFilenames[i], cache, diags)
+		return nil
+	})
+
+	articles := make([]Article, 0)
+	for _, article := range parsed {
+		if article != nil {
+			articles = append(articles, *article)
+		}
+	}
+	sortArticles(articles)
+	checkArticleAliases(config, articles, diags)
 	return articles, nil
 }
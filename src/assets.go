@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// fingerprintedDirs are copied from srcDir with a hash of their content
+	// appended to every filename (global.css -> global.3f9a1c.css), so that
+	// they can be cached forever by browsers. Stylesheets and scripts are
+	// minified on the way.
+	fingerprintedDirs = []string{"css", "images", "scripts"}
+
+	// verbatimDirs and verbatimFiles are copied from srcDir as is. Their
+	// files reference each other by paths that must remain stable (KaTeX and
+	// Font Awesome fonts), or are linked from outside the site.
+	verbatimDirs  = []string{"fonts", "webfonts", "pdfs", "libs"}
+	verbatimFiles = []string{"robots.txt"}
+
+	assetReferenceRegex = regexp.MustCompile(`(\s(?:src|href)=")(?:\./)?([^"#?:]+)([#?][^"]*)?(")`)
+	srcsetRegex         = regexp.MustCompile(`(\ssrcset=")([^"]*)(")`)
+)
+
+// AssetManifest maps the logical path of a published asset, relative to the
+// output directory (e.g. "css/global.css"), to the path it was actually
+// published at (e.g. "css/global.3f9a1c.css").
+type AssetManifest map[string]string
+
+// publishedAssets is the manifest of the last call to publishAssets. It is
+// read by templates through assetURL.
+var publishedAssets = AssetManifest{}
+
+// assetURL returns the published path of the asset at logical path p, or p
+// itself when it was not published by the generator.
+func assetURL(p string) string {
+	if published, ok := publishedAssets[p]; ok {
+		return published
+	}
+	return p
+}
+
+// assetSource is a file to publish: where it comes from, the logical path it
+// is published under, and whether a content hash goes into its filename.
+type assetSource struct {
+	Logical     string
+	Src         string
+	Fingerprint bool
+	Minify      func(string) (string, error)
+}
+
+// fingerprintedPath inserts a short content hash before the extension of p.
+func fingerprintedPath(p, hash string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + hash[:6] + ext
+}
+
+// copyFile copies src to dst, creating dst's parent directories as needed.
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// collectAssetSources lists every file the generator publishes. Two sources
+// mapping to the same logical path are reported as an error instead of
+// silently overwriting each other.
+func collectAssetSources(config Config) ([]assetSource, error) {
+	var sources []assetSource
+	owners := make(map[string]string)
+
+	add := func(source assetSource) error {
+		if owner, exists := owners[source.Logical]; exists {
+			return fmt.Errorf("asset collision: %s and %s are both published as %s", owner, source.Src, source.Logical)
+		}
+		owners[source.Logical] = source.Src
+		sources = append(sources, source)
+		return nil
+	}
+
+	// minifierFor returns the minifier applied to a published file, if any.
+	minifierFor := func(filename string) func(string) (string, error) {
+		switch filepath.Ext(filename) {
+		case ".css":
+			return MinifyCSS
+		case ".js":
+			return MinifyJS
+		}
+		return nil
+	}
+
+	addDir := func(dir string, fingerprint bool) error {
+		root := filepath.Join(config.SrcDir, dir)
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == root {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(config.SrcDir, p)
+			if err != nil {
+				return err
+			}
+			source := assetSource{Logical: filepath.ToSlash(rel), Src: p, Fingerprint: fingerprint}
+			if fingerprint {
+				source.Minify = minifierFor(p)
+			}
+			return add(source)
+		})
+	}
+
+	for _, dir := range fingerprintedDirs {
+		if err := addDir(dir, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range verbatimDirs {
+		if err := addDir(dir, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, filename := range verbatimFiles {
+		if err := add(assetSource{Logical: filename, Src: filepath.Join(config.SrcDir, filename)}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Article styles and scripts are flattened next to the global ones.
+	files, err := os.ReadDir(config.ArticleDir)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening directory '%s': '%w'", config.ArticleDir, err)
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		var subdir string
+		switch filepath.Ext(file.Name()) {
+		case ".css":
+			subdir = "css"
+		case ".js":
+			subdir = "scripts"
+		default:
+			continue
+		}
+		err := add(assetSource{
+			Logical:     subdir + "/" + file.Name(),
+			Src:         filepath.Join(config.ArticleDir, file.Name()),
+			Fingerprint: true,
+			Minify:      minifierFor(file.Name()),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The files of a bundle are published under the directory of its page
+	// and keep their names, so that they can reference each other (a script
+	// fetching data.json next to it). Its manifest and markdown are not.
+	bundles, err := articleBundles(config.ArticleDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		root := filepath.Join(config.ArticleDir, bundle)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || d.Name() == bundleManifestFilename || filepath.Ext(p) == ".md" {
+				return nil
+			}
+			rel, err := filepath.Rel(config.ArticleDir, p)
+			if err != nil {
+				return err
+			}
+			return add(assetSource{Logical: filepath.ToSlash(rel), Src: p, Minify: minifierFor(p)})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Logical < sources[j].Logical })
+	return sources, nil
+}
+
+// publishAssets stages every static asset in out, fingerprinting the ones
+// that are referenced from generated pages, along with the resized variants
+// of the images of articles, and returns the resulting manifest. It also
+// becomes the manifest used by assetURL. Assets whose content did not
+// change are not rewritten.
+func publishAssets(config Config, out *outputWriter, cache *buildCache, articles []Article) (AssetManifest, error) {
+	sources, err := collectAssetSources(config)
+	if err != nil {
+		return nil, err
+	}
+
+	publishedPaths := make([]string, len(sources))
+	err = forEachParallel(len(sources), config.Workers, func(i int) error {
+		source := sources[i]
+		content, err := os.ReadFile(source.Src)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", source.Src, err)
+		}
+		if source.Minify != nil {
+			minified, err := source.Minify(source.Src)
+			if err != nil {
+				return fmt.Errorf("failed to minify %s: %v", source.Src, err)
+			}
+			content = []byte(minified)
+		}
+
+		// The fingerprint is a hash of the published bytes alone: the same
+		// content gets the same URL wherever the site is built from, and
+		// new bytes, minifier changes included, always get a new URL.
+		hash := hashBytes(content)
+		published := source.Logical
+		if source.Fingerprint {
+			published = fingerprintedPath(source.Logical, hash)
+		}
+		publishedPaths[i] = published
+		if cache.upToDate(config.OutputDir, published, hash) {
+			return nil
+		}
+		return out.WriteFile(published, content, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make(AssetManifest, len(sources))
+	for i, source := range sources {
+		manifest[source.Logical] = publishedPaths[i]
+	}
+	if err := publishImageVariants(config, out, cache, articles, manifest); err != nil {
+		return nil, err
+	}
+
+	publishedAssets = manifest
+	return manifest, nil
+}
+
+// assetReferenceRewriter points references to published assets in
+// rendered pages at their published path. With pretty URLs, pages are one
+// directory deeper: relative references become root-relative, and links to
+// pages use their /slug/ URL. The same goes for pages served at any URL.
+type assetReferenceRewriter struct{}
+
+func (assetReferenceRewriter) Name() string { return "asset references" }
+
+func (assetReferenceRewriter) Transform(site *Site, page Page, html string) (string, error) {
+	rootRelative := site.Config.PrettyURLs || page.RootRelative
+	return rewriteAssetReferences(html, site.Assets, site.Config, rootRelative), nil
+}
+
+// rewriteAssetReferences replaces src, href and srcset attributes pointing
+// at the logical path of a published asset (e.g. "./images/foo.png" in
+// article markdown) with the path it was published at. With rootRelative,
+// every relative reference is made root-relative, and references to pages
+// ("articles.html#top") use config.pageURL ("/articles/#top" with pretty
+// URLs).
+func rewriteAssetReferences(html string, manifest AssetManifest, config Config, rootRelative bool) string {
+	// rewrite returns the reference replacing ref, or false to leave it.
+	rewrite := func(ref string) (string, bool) {
+		ref = path.Clean(ref)
+		published, ok := manifest[ref]
+		switch {
+		case !rootRelative && !ok:
+			return "", false
+		case !rootRelative:
+		case strings.HasPrefix(ref, "/"):
+			return "", false
+		case ok:
+			published = "/" + published
+		case path.Ext(ref) == ".html":
+			published = config.pageURL(ref)
+		default:
+			published = "/" + ref
+		}
+		return published, true
+	}
+
+	html = assetReferenceRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := assetReferenceRegex.FindStringSubmatch(match)
+		published, ok := rewrite(parts[2])
+		if !ok {
+			return match
+		}
+		return parts[1] + published + parts[3] + parts[4]
+	})
+
+	return srcsetRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := srcsetRegex.FindStringSubmatch(match)
+		candidates := strings.Split(parts[2], ",")
+		for i, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 || strings.ContainsAny(fields[0], "#?:") {
+				continue
+			}
+			if published, ok := rewrite(strings.TrimPrefix(fields[0], "./")); ok {
+				fields[0] = published
+			}
+			candidates[i] = strings.Join(fields, " ")
+		}
+		return parts[1] + strings.Join(candidates, ", ") + parts[3]
+	})
+}
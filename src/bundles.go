@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// An article bundle is a directory of the article directory holding an
+// article and the files it uses:
+//
+//	articles/slug/manifest.json
+//	articles/slug/index.md
+//	articles/slug/images/figure.png
+//	articles/slug/demo.js
+//
+// The article is published as slug.html, and every other file of the
+// directory under slug/, next to it.
+const (
+	bundleManifestFilename = "manifest.json"
+	bundleMarkdownFilename = "index.md"
+)
+
+// articleBundles returns the directories of articleDir that are article
+// bundles.
+func articleBundles(articleDir string) ([]string, error) {
+	files, err := os.ReadDir(articleDir)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening directory '%s': '%w'", articleDir, err)
+	}
+	var bundles []string
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(articleDir, file.Name(), bundleManifestFilename)); err == nil {
+			bundles = append(bundles, file.Name())
+		}
+	}
+	return bundles, nil
+}
+
+// listArticleManifests returns the manifests of articleDir, relative to
+// it: the JSON files of flat articles (slug.json) and the manifests of
+// bundles (slug/manifest.json).
+func listArticleManifests(articleDir string) ([]string, error) {
+	files, err := os.ReadDir(articleDir)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening directory '%s': '%w'", articleDir, err)
+	}
+	var manifestFilenames []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			manifestFilenames = append(manifestFilenames, file.Name())
+		}
+	}
+	bundles, err := articleBundles(articleDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, bundle := range bundles {
+		manifestFilenames = append(manifestFilenames, filepath.Join(bundle, bundleManifestFilename))
+	}
+	return manifestFilenames, nil
+}
+
+// articleBundle returns the bundle of the manifest at manifestFilename,
+// relative to the article directory, or "" for a flat article.
+func articleBundle(manifestFilename string) string {
+	if dir := path.Dir(filepath.ToSlash(manifestFilename)); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// isArticleManifest reports whether rel, relative to the article
+// directory, is the manifest of an article rather than a file it uses.
+func isArticleManifest(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if bundle := articleBundle(rel); bundle != "" {
+		return rel == bundle+"/"+bundleManifestFilename && !strings.Contains(bundle, "/")
+	}
+	return path.Ext(rel) == ".json"
+}
+
+// loadArticleManifest reads the manifest at manifestFilename, relative to
+// articleDir. The files named by the manifest of a bundle are relative to
+// the bundle; they are made relative to articleDir like those of flat
+// articles, the markdown file defaulting to index.md.
+func loadArticleManifest(articleDir, manifestFilename string) (*ArticleManifest, error) {
+	manifest, err := readArticleManifest(filepath.Join(articleDir, manifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	bundle := articleBundle(manifestFilename)
+	if bundle == "" {
+		return manifest, nil
+	}
+	if manifest.MarkdownFile == "" {
+		manifest.MarkdownFile = bundleMarkdownFilename
+	}
+	for _, file := range []*string{&manifest.MarkdownFile, &manifest.CssFile, &manifest.ScriptFile} {
+		if *file != "" {
+			*file = path.Join(bundle, *file)
+		}
+	}
+	return manifest, nil
+}
+
+// articleHTMLFilename returns the page of the article declared by
+// manifest: slug.html for both slug.json and slug/manifest.json.
+func articleHTMLFilename(manifestFilename string, manifest *ArticleManifest) string {
+	if bundle := articleBundle(manifestFilename); bundle != "" {
+		return bundle + ".html"
+	}
+	return strings.TrimSuffix(manifest.MarkdownFile, ".md") + ".html"
+}
+
+// rebaseBundleReferences makes the relative src, href and srcset references
+// of the HTML of a bundle, written relative to the bundle directory,
+// relative to the article directory the pages are generated from:
+// images/figure.png becomes slug/images/figure.png, and ../other.html
+// becomes other.html.
+func rebaseBundleReferences(html, bundle string) string {
+	// rebase leaves alone references that are not relative paths: query
+	// strings and fragments are matched apart by the regexes.
+	rebase := func(p string) string {
+		if strings.HasPrefix(p, "/") || strings.ContainsAny(p, ":#?") {
+			return p
+		}
+		return path.Join(bundle, p)
+	}
+
+	html = assetReferenceRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := assetReferenceRegex.FindStringSubmatch(match)
+		return parts[1] + rebase(parts[2]) + parts[3] + parts[4]
+	})
+	return srcsetRegex.ReplaceAllStringFunc(html, func(match string) string {
+		parts := srcsetRegex.FindStringSubmatch(match)
+		candidates := strings.Split(parts[2], ",")
+		for i, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 {
+				continue
+			}
+			fields[0] = rebase(fields[0])
+			candidates[i] = strings.Join(fields, " ")
+		}
+		return parts[1] + strings.Join(candidates, ", ") + parts[3]
+	})
+}
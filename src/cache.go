@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// buildCacheFilename is the location of the build cache, relative to the
+// output directory.
+const buildCacheFilename = ".cache/build.json"
+
+// cachedArticle is a parsed article along with the hash of the inputs it
+// was parsed from and the warnings reported while parsing it.
+type cachedArticle struct {
+	Hash     string       `json:"hash"`
+	Article  Article      `json:"article"`
+	Warnings []Diagnostic `json:"warnings,omitempty"`
+}
+
+// buildCache records hashes of every build input so that unchanged articles
+// are not re-parsed and unchanged output files are not rewritten.
+//
+// It is persisted between runs in <OutputDir>/.cache/build.json. A nil
+// *buildCache is valid and disables caching. All methods are safe for
+// concurrent use.
+type buildCache struct {
+	mu sync.Mutex
+
+	// Generator is a hash of the generator binary itself: any change to the
+	// Go code or the compiled templ templates invalidates the whole cache.
+	Generator string                   `json:"generator"`
+	Articles  map[string]cachedArticle `json:"articles"` // keyed by manifest filename
+	Outputs   map[string]string        `json:"outputs"`  // output path -> input hash
+
+	path            string
+	touchedArticles map[string]bool
+	touchedOutputs  map[string]bool
+}
+
+// hashBytes returns the hex-encoded SHA-256 of the concatenation of parts.
+func hashBytes(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// Length prefix so that ("ab", "c") and ("a", "bc") differ.
+		fmt.Fprintf(h, "%d:", len(part))
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFiles returns a hash of the content of every file in paths.
+func hashFiles(paths ...string) (string, error) {
+	var parts [][]byte
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, []byte(path), content)
+	}
+	return hashBytes(parts...), nil
+}
+
+// generatorHash hashes the running executable.
+func generatorHash() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return hashFiles(executable)
+}
+
+// loadBuildCache reads the build cache from outputDir. A missing or
+// unreadable cache, a cache produced by a different generator binary, or
+// force being set all yield an empty cache.
+func loadBuildCache(outputDir string, force bool) (*buildCache, error) {
+	generator, err := generatorHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash generator: %v", err)
+	}
+
+	cache := &buildCache{
+		Generator:       generator,
+		Articles:        make(map[string]cachedArticle),
+		Outputs:         make(map[string]string),
+		path:            filepath.Join(outputDir, buildCacheFilename),
+		touchedArticles: make(map[string]bool),
+		touchedOutputs:  make(map[string]bool),
+	}
+	if force {
+		return cache, nil
+	}
+
+	content, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache, nil
+	}
+
+	var stored buildCache
+	if err := json.Unmarshal(content, &stored); err != nil || stored.Generator != generator {
+		return cache, nil
+	}
+	if stored.Articles != nil {
+		cache.Articles = stored.Articles
+	}
+	if stored.Outputs != nil {
+		cache.Outputs = stored.Outputs
+	}
+	return cache, nil
+}
+
+// article returns the cached article for manifestFilename and its warnings
+// if it was parsed from inputs with the same hash.
+func (c *buildCache) article(manifestFilename, hash string) (*Article, []Diagnostic, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Articles[manifestFilename]
+	if !ok || entry.Hash != hash {
+		return nil, nil, false
+	}
+	c.touchedArticles[manifestFilename] = true
+	return &entry.Article, entry.Warnings, true
+}
+
+// storeArticle records a freshly parsed article and its warnings.
+func (c *buildCache) storeArticle(manifestFilename, hash string, a Article, warnings []Diagnostic) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Articles[manifestFilename] = cachedArticle{Hash: hash, Article: a, Warnings: warnings}
+	c.touchedArticles[manifestFilename] = true
+}
+
+// upToDate reports whether the output file at path was produced from inputs
+// with the given hash and still exists on disk.
+func (c *buildCache) upToDate(outputDir, path, hash string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.touchedOutputs[path] = true
+	if c.Outputs[path] != hash {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(outputDir, path))
+	return err == nil
+}
+
+// storeOutput records the input hash of a freshly written output file.
+func (c *buildCache) storeOutput(path, hash string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Outputs[path] = hash
+	c.touchedOutputs[path] = true
+}
+
+// prune drops entries that were not used during this build (deleted
+// articles, removed pages, previous fingerprints of changed assets...) and
+// returns the output paths that are now stale. Only meaningful after a full
+// build.
+func (c *buildCache) prune() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := range c.Articles {
+		if !c.touchedArticles[name] {
+			delete(c.Articles, name)
+		}
+	}
+	var stale []string
+	for path := range c.Outputs {
+		if !c.touchedOutputs[path] {
+			delete(c.Outputs, path)
+			stale = append(stale, path)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// save writes the cache to disk.
+func (c *buildCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	content, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal build cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, content, 0644)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// command is a subcommand of the generator CLI.
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) error
+}
+
+// commands lists every subcommand, in the order they are shown in --help.
+var commands = []command{
+	{Name: "build", Summary: "Generate the site into the output directory (default)", Run: runBuild},
+	{Name: "serve", Summary: "Build, serve the output directory and rebuild on change", Run: runServe},
+	{Name: "new", Summary: "Scaffold a new draft article", Run: runNew},
+	{Name: "check", Summary: "Parse every article, drafts included, check links and report every problem", Run: runCheck},
+	{Name: "clean", Summary: "Remove everything from the output directory", Run: runClean},
+	{Name: "stats", Summary: "Print article and output statistics", Run: runStats},
+}
+
+// errUsage signals that usage was already printed and the process should
+// exit with a non-zero code without printing anything else.
+var errUsage = errors.New("usage")
+
+// printUsage writes the top-level help to w.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: blog <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(w, "\nRun 'blog <command> --help' for the flags of a command.\n")
+	fmt.Fprintf(w, "Flags override the matching environment variables (ARTICLE_DIR, OUTPUT_DIR,\n")
+	fmt.Fprintf(w, "SRC_DIR, ENV, BASE_URL, WORKERS, MINIFY_HTML, PRETTY_URLS).\n")
+}
+
+// runCLI dispatches args (without the program name) to a subcommand.
+// Without a command, or when the first argument is a flag, it builds.
+func runCLI(args []string) error {
+	if len(args) == 0 {
+		return runBuild(args)
+	}
+
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		printUsage(os.Stdout)
+		return nil
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runBuild(args)
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == args[0] {
+			return cmd.Run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return errUsage
+}
+
+// newFlagSet returns a FlagSet for the named command whose usage message
+// lists the command summary and its flags.
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: blog %s %s\n\nFlags:\n", name, synopsis)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args, mapping a --help request to a nil error after the
+// usage was printed, and any other parsing error to errUsage.
+func parseFlags(flags *flag.FlagSet, args []string) (bool, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return false, nil
+		}
+		return false, errUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return false, errUsage
+	}
+	return true, nil
+}
+
+func runBuild(args []string) error {
+	flags := newFlagSet("build", "[flags]")
+	overrides := addConfigFlags(flags)
+	force := flags.Bool("force", false, "ignore the build cache and rebuild everything")
+	skipBroken := flags.Bool("skip-broken", false, "leave out drafts that fail to parse instead of failing the build")
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+	config.Force = *force
+	config.SkipBroken = *skipBroken
+	InitMinifier()
+
+	cache, err := loadBuildCache(config.OutputDir, config.Force)
+	if err != nil {
+		return fmt.Errorf("error loading build cache: %v", err)
+	}
+	_, err = build(config, cache)
+	return err
+}
+
+func runServe(args []string) error {
+	flags := newFlagSet("serve", "[flags]")
+	overrides := addConfigFlags(flags)
+	force := flags.Bool("force", false, "ignore the build cache for the initial build")
+	addr := flags.String("addr", ":8080", "address to listen on")
+	skipBroken := flags.Bool("skip-broken", false, "leave out drafts that fail to parse instead of failing the build")
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+	config.Force = *force
+	config.SkipBroken = *skipBroken
+	InitMinifier()
+
+	cache, err := loadBuildCache(config.OutputDir, config.Force)
+	if err != nil {
+		return fmt.Errorf("error loading build cache: %v", err)
+	}
+	return serve(config, cache, *addr)
+}
+
+func runNew(args []string) error {
+	flags := newFlagSet("new", "[flags]")
+	overrides := addConfigFlags(flags)
+	var opts newArticleOptions
+	flags.StringVar(&opts.Slug, "slug", "", "article slug, lowercase and hyphenated")
+	flags.StringVar(&opts.Title, "title", "", "article title")
+	flags.StringVar(&opts.Description, "description", "", "short summary shown in cards and meta tags")
+	flags.StringVar(&opts.Tags, "tags", "", "comma-separated tags, among articleTags in site.json")
+	flags.BoolVar(&opts.Bundle, "bundle", false, "create a bundle directory, articles/<slug>/, for an article with its own files")
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+	return newArticle(config, opts, os.Stdin, os.Stdout)
+}
+
+func runCheck(args []string) error {
+	flags := newFlagSet("check", "[flags]")
+	overrides := addConfigFlags(flags)
+	skipBroken := flags.Bool("skip-broken", false, "report broken drafts as warnings instead of errors")
+	external := flags.Bool("external", false, "also check the external links of articles over HTTP")
+	externalBaseURL := flags.String("external-base-url", "", "request external links from this server instead, as <url>/<host>/<path>")
+	externalWorkers := flags.Int("external-workers", 8, "number of concurrent external link requests")
+	externalInterval := flags.Duration("external-interval", time.Second, "minimum delay between requests to the same host")
+	externalRetries := flags.Int("external-retries", 2, "retries of external links failing with a network error, 429 or 5xx")
+	externalTimeout := flags.Duration("external-timeout", 10*time.Second, "timeout of an external link request")
+	externalTTL := flags.Duration("external-ttl", 7*24*time.Hour, "how long a working external link is not checked again")
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+	config.Env = "development"
+	config.SkipBroken = *skipBroken
+	// Building logs transformer reports, irrelevant to a check.
+	config.Quiet = true
+	InitMinifier()
+
+	// Articles are parsed, and their images resized, for a site rendered in
+	// a temporary directory: check leaves the output directory alone, but
+	// for the cache of external links.
+	tmp, err := os.MkdirTemp("", "blog-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	outputDir := config.OutputDir
+	config.OutputDir = filepath.Join(tmp, filepath.Base(outputDir))
+
+	diags := &Diagnostics{}
+	articles, err := parseArticles(config, nil, diags)
+	if err != nil {
+		return err
+	}
+	if !diags.HasErrors() {
+		redirectRules(&Site{Config: config, Articles: articles}, diags)
+	}
+	if !diags.HasErrors() {
+		if err := checkGeneratedLinks(config, outputDir, articles, diags); err != nil {
+			return err
+		}
+	}
+	if *external {
+		checker := &externalLinkChecker{
+			Client:   &http.Client{Timeout: *externalTimeout},
+			BaseURL:  *externalBaseURL,
+			Workers:  *externalWorkers,
+			Interval: *externalInterval,
+			Retries:  *externalRetries,
+			TTL:      *externalTTL,
+		}
+		if err := checkExternalLinks(checker, articles, outputDir, os.Stdout, diags); err != nil {
+			return err
+		}
+	}
+	diags.Print(os.Stderr)
+	if diags.HasErrors() {
+		return fmt.Errorf("check failed: %s", diags.Summary())
+	}
+	fmt.Printf("%d articles OK\n", len(articles))
+	return nil
+}
+
+func runClean(args []string) error {
+	flags := newFlagSet("clean", "[flags]")
+	overrides := addConfigFlags(flags)
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(config.OutputDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if err := checkCleanable(config); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(config.OutputDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCleanable refuses to clean an output directory that is, or holds,
+// the filesystem root, the home directory, the repository or the article
+// and source directories, or that was never generated by a build: clean
+// removes everything in it.
+func checkCleanable(config Config) error {
+	dir, err := filepath.Abs(config.OutputDir)
+	if err != nil {
+		return err
+	}
+	protected := []string{findRepoRoot(), config.ArticleDir, config.SrcDir}
+	if home, err := os.UserHomeDir(); err == nil {
+		protected = append(protected, home)
+	}
+	for _, p := range protected {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to clean %s: it holds %s", dir, p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, buildCacheFilename)); err != nil {
+		return fmt.Errorf("refusing to clean %s: it was not generated by a build (no %s)", dir, buildCacheFilename)
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	flags := newFlagSet("stats", "[flags]")
+	overrides := addConfigFlags(flags)
+	if ok, err := parseFlags(flags, args); !ok {
+		return err
+	}
+
+	config, err := LoadConfig(*overrides)
+	if err != nil {
+		return err
+	}
+
+	manifestFilenames, err := listArticleManifests(config.ArticleDir)
+	if err != nil {
+		return err
+	}
+
+	published, drafts, totalWords := 0, 0, 0
+	fmt.Printf("%-45s %-10s %6s %8s\n", "ARTICLE", "DATE", "WORDS", "READING")
+	for _, manifestFilename := range manifestFilenames {
+		manifest, err := loadArticleManifest(config.ArticleDir, manifestFilename)
+		if err != nil {
+			return fmt.Errorf("%s: %v", manifestFilename, err)
+		}
+		markdown, err := os.ReadFile(filepath.Join(config.ArticleDir, manifest.MarkdownFile))
+		if err != nil {
+			return fmt.Errorf("%s: %v", manifestFilename, err)
+		}
+
+		words := len(strings.Fields(string(markdown)))
+		totalWords += words
+		name := strings.TrimSuffix(articleHTMLFilename(manifestFilename, manifest), ".html")
+		if manifest.Draft {
+			drafts++
+			name += " (draft)"
+		} else {
+			published++
+		}
+		fmt.Printf("%-45s %-10s %6d %6dmin\n", name, manifest.Date, words, readingMinutes(words))
+	}
+
+	fmt.Printf("\n%d published, %d drafts, %d words\n", published, drafts, totalWords)
+
+	outputFiles, outputBytes := 0, int64(0)
+	err = filepath.WalkDir(config.OutputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".cache" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		outputFiles++
+		outputBytes += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		fmt.Printf("Output directory %s does not exist yet\n", config.OutputDir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Output: %d files, %.1f KiB in %s\n", outputFiles, float64(outputBytes)/1024, config.OutputDir)
+	return nil
+}
+
+// readingMinutes estimates the reading time of a text, rounded up, at
+// 200 words per minute.
+func readingMinutes(words int) int {
+	return (words + 199) / 200
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Files read by Cloudflare Pages at the root of the deployed directory.
+// https://developers.cloudflare.com/pages/configuration/headers/
+// https://developers.cloudflare.com/pages/configuration/redirects/
+const (
+	headersFilename   = "_headers"
+	redirectsFilename = "_redirects"
+)
+
+var (
+	// securityHeaders are sent with every response. The Content-Security-
+	// Policy of a page lives in its <meta> tag (see csp.go); frame-ancestors
+	// is only honored when sent as a header, and combines with it.
+	securityHeaders = [][2]string{
+		{"X-Content-Type-Options", "nosniff"},
+		{"X-Frame-Options", "DENY"},
+		{"Content-Security-Policy", "frame-ancestors 'none'"},
+		{"Referrer-Policy", "strict-origin-when-cross-origin"},
+		{"Permissions-Policy", "camera=(), microphone=(), geolocation=()"},
+	}
+
+	// Pages may change on every deployment and are revalidated quickly.
+	// Fingerprinted assets change name whenever their content changes and
+	// are cached forever. Verbatim assets keep their name across changes.
+	pageCacheControl        = "public, max-age=300, must-revalidate"
+	fingerprintCacheControl = "public, max-age=31536000, immutable"
+	verbatimCacheControl    = "public, max-age=86400"
+
+	// redirectStatuses are the status codes Cloudflare Pages accepts in
+	// _redirects. 200 serves the target without redirecting.
+	redirectStatuses = map[int]bool{200: true, 301: true, 302: true, 303: true, 307: true, 308: true}
+
+	// redirectPlaceholderRegex matches the :name placeholders of a rule.
+	redirectPlaceholderRegex = regexp.MustCompile(`:[A-Za-z]\w*`)
+)
+
+// Cloudflare Pages limits of the _redirects file.
+const (
+	maxStaticRedirects  = 2000
+	maxDynamicRedirects = 100
+)
+
+// headersGenerator adds the _headers file setting caching and security
+// headers.
+type headersGenerator struct{}
+
+func (headersGenerator) Name() string { return "headers" }
+
+func (headersGenerator) Pages(site *Site) []Page { return nil }
+
+func (headersGenerator) PostProcess(site *Site, out *outputWriter) error {
+	return out.WriteFile(headersFilename, []byte(generateHeaders()), "")
+}
+
+// generateHeaders returns the content of the _headers file. Every path
+// first gets the security headers and the page caching policy; asset
+// directories then detach that Cache-Control for their own.
+func generateHeaders() string {
+	var b strings.Builder
+	b.WriteString("# Generated by the blog generator, do not edit.\n")
+	b.WriteString("/*\n")
+	for _, header := range securityHeaders {
+		fmt.Fprintf(&b, "  %s: %s\n", header[0], header[1])
+	}
+	fmt.Fprintf(&b, "  Cache-Control: %s\n", pageCacheControl)
+
+	assetDirs := func(dirs []string, cacheControl string) {
+		for _, dir := range dirs {
+			fmt.Fprintf(&b, "\n/%s/*\n  ! Cache-Control\n  Cache-Control: %s\n", dir, cacheControl)
+		}
+	}
+	assetDirs(fingerprintedDirs, fingerprintCacheControl)
+	assetDirs(verbatimDirs, verbatimCacheControl)
+	return b.String()
+}
+
+// redirectRule is a line of the _redirects file, along with where it was
+// declared.
+type redirectRule struct {
+	From     string
+	To       string
+	Status   int
+	Position Position
+}
+
+// dynamic reports whether the rule has a splat or placeholders.
+func (r redirectRule) dynamic() bool {
+	return strings.Contains(r.From, "*") || redirectPlaceholderRegex.MatchString(r.From)
+}
+
+// redirectsGenerator adds the _redirects file, built from the aliases of
+// articles and the redirects of site.json.
+type redirectsGenerator struct{}
+
+func (redirectsGenerator) Name() string { return "redirects" }
+
+func (redirectsGenerator) Pages(site *Site) []Page { return nil }
+
+func (redirectsGenerator) PostProcess(site *Site, out *outputWriter) error {
+	var diags Diagnostics
+	rules := redirectRules(site, &diags)
+	if err := diags.Err(); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by the blog generator, do not edit.\n")
+	for _, rule := range rules {
+		fmt.Fprintf(&b, "%s %s %d\n", rule.From, rule.To, rule.Status)
+	}
+	return out.WriteFile(redirectsFilename, []byte(b.String()), "")
+}
+
+// redirectRules returns the rules of the _redirects file: the aliases of
+// every article, redirected to it with a 301, the former flat URL of every
+// page with pretty URLs, then the redirects of site.json. Invalid and
+// conflicting rules are reported in diags.
+func redirectRules(site *Site, diags *Diagnostics) []redirectRule {
+	var rules []redirectRule
+	for _, article := range site.Articles {
+		manifestPath := filepath.Join(site.Config.ArticleDir, article.ManifestFilename)
+		for _, alias := range article.Manifest.Aliases {
+			rules = append(rules, redirectRule{
+				From:     alias,
+				To:       site.Config.pageURL(article.HTMLFilename),
+				Status:   301,
+				Position: manifestKeyPosition(manifestPath, "aliases"),
+			})
+		}
+	}
+
+	if site.Config.PrettyURLs {
+		for _, g := range generators {
+			if _, ok := g.(aliasPages); ok {
+				continue
+			}
+			for _, page := range g.Pages(site) {
+				dir, ok := strings.CutSuffix(page.Filename, "/index.html")
+				if !ok {
+					continue
+				}
+				rules = append(rules, redirectRule{
+					From:     "/" + dir + ".html",
+					To:       site.Config.pageURL(dir + ".html"),
+					Status:   301,
+					Position: Position{File: redirectsFilename},
+				})
+			}
+		}
+	}
+
+	siteConfigPath := filepath.Join(site.Config.SrcDir, "site.json")
+	for _, redirect := range site.Config.Site.Redirects {
+		status := redirect.Status
+		if status == 0 {
+			status = 301
+		}
+		rules = append(rules, redirectRule{
+			From:     redirect.From,
+			To:       redirect.To,
+			Status:   status,
+			Position: manifestKeyPosition(siteConfigPath, "redirects"),
+		})
+	}
+
+	validateRedirectRules(site, rules, diags)
+	return rules
+}
+
+// validateRedirectRules reports rules Cloudflare Pages would reject or
+// that would not behave as intended: malformed paths, rules shadowing a
+// generated file or another rule, loops, and chains of redirects.
+func validateRedirectRules(site *Site, rules []redirectRule, diags *Diagnostics) {
+	// The redirect stubs of aliases are meant to be shadowed by their rule.
+	generated := make(map[string]bool)
+	for _, g := range generators {
+		if _, ok := g.(aliasPages); ok {
+			continue
+		}
+		for _, page := range g.Pages(site) {
+			generated["/"+page.Filename] = true
+			// A directory index is also served at the directory, with
+			// and without a trailing slash.
+			if dir, ok := strings.CutSuffix("/"+page.Filename, "/index.html"); ok {
+				generated[dir+"/"] = true
+				if dir != "" {
+					generated[dir] = true
+				}
+			}
+		}
+	}
+	for _, published := range site.Assets {
+		generated["/"+published] = true
+	}
+
+	sources := make(map[string]redirectRule)
+	var static, dynamic int
+	for _, rule := range rules {
+		pos := rule.Position
+		switch {
+		case !strings.HasPrefix(rule.From, "/"):
+			diags.Errorf(pos, "redirect source %q must start with /", rule.From)
+			continue
+		case strings.ContainsAny(rule.From+rule.To, " \t\r\n"):
+			diags.Errorf(pos, "redirect %s -> %s must not contain whitespace", rule.From, rule.To)
+			continue
+		case !strings.HasPrefix(rule.To, "/") && !strings.HasPrefix(rule.To, "https://") && !strings.HasPrefix(rule.To, "http://"):
+			diags.Errorf(pos, "redirect target %q must be a path starting with / or an absolute URL", rule.To)
+			continue
+		case !redirectStatuses[rule.Status]:
+			diags.Errorf(pos, "redirect %s has unsupported status %d", rule.From, rule.Status)
+			continue
+		}
+
+		for _, placeholder := range redirectPlaceholderRegex.FindAllString(rule.To, -1) {
+			if placeholder == ":splat" {
+				if !strings.Contains(rule.From, "*") {
+					diags.Errorf(pos, "redirect target %s uses :splat but its source %s has no *", rule.To, rule.From)
+				}
+			} else if !slices.Contains(redirectPlaceholderRegex.FindAllString(rule.From, -1), placeholder) {
+				diags.Errorf(pos, "redirect target %s uses %s, which its source %s does not define", rule.To, placeholder, rule.From)
+			}
+		}
+
+		if generated[rule.From] {
+			diags.Errorf(pos, "redirect source %s is a generated file", rule.From)
+		}
+		if other, exists := sources[rule.From]; exists {
+			diags.Errorf(pos, "redirect source %s is already redirected at %s", rule.From, other.Position)
+		}
+		sources[rule.From] = rule
+
+		if rule.dynamic() {
+			dynamic++
+		} else {
+			static++
+		}
+	}
+
+	for _, rule := range rules {
+		if rule.From == rule.To {
+			diags.Errorf(rule.Position, "redirect %s points to itself", rule.From)
+		} else if next, exists := sources[rule.To]; exists && rule.Status != 200 {
+			diags.Errorf(rule.Position, "redirect %s -> %s is followed by %s -> %s: redirect to %s directly", rule.From, rule.To, next.From, next.To, next.To)
+		}
+	}
+
+	if static > maxStaticRedirects {
+		diags.Errorf(Position{File: redirectsFilename}, "%d static redirects exceed the limit of %d", static, maxStaticRedirects)
+	}
+	if dynamic > maxDynamicRedirects {
+		diags.Errorf(Position{File: redirectsFilename}, "%d dynamic redirects exceed the limit of %d", dynamic, maxDynamicRedirects)
+	}
+}
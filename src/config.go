@@ -1,51 +1,133 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"io/fs"
-	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 )
 
 // Config holds all runtime configuration for the site generator,
-// derived from environment variables.
+// derived from command line flags, environment variables and defaults,
+// in that order of precedence.
 type Config struct {
 	ArticleDir string
 	OutputDir  string
 	SrcDir     string
 	Env        string
 	BaseURL    string
-	CSSFiles   []string
-	FileFlags  int
 	FileMode   fs.FileMode
+	Workers    int
+	Force      bool // ignore the build cache, set from the --force flag
+	SkipBroken bool // leave broken drafts out instead of failing, set from the --skip-broken flag
+	MinifyHTML bool // minify rendered pages, off by default in development
+	PrettyURLs bool // write pages to slug/index.html and link them as /slug/
+	Quiet      bool // do not log the reports of transformers, set by check
+	Site       SiteConfig
 }
 
-// LoadConfig reads environment variables to produce a Config.
-// Exits fatally if required variables are missing.
-func LoadConfig() Config {
-	articleDir := os.Getenv("ARTICLE_DIR")
-	outputDir := os.Getenv("OUTPUT_DIR")
-	srcDir := os.Getenv("SRC_DIR")
+// configFlags holds the values of the command line flags that override
+// environment variables. Empty values mean "not set".
+type configFlags struct {
+	ArticleDir string
+	OutputDir  string
+	SrcDir     string
+	Env        string
+	BaseURL    string
+	Workers    int
+	MinifyHTML string
+	PrettyURLs string
+}
+
+// addConfigFlags registers the flags shared by every command on flags.
+func addConfigFlags(flags *flag.FlagSet) *configFlags {
+	var c configFlags
+	flags.StringVar(&c.ArticleDir, "article-dir", "", "directory holding article manifests and markdown (env ARTICLE_DIR, default <repo>/articles)")
+	flags.StringVar(&c.OutputDir, "output-dir", "", "directory the site is generated into (env OUTPUT_DIR, default <repo>/web)")
+	flags.StringVar(&c.SrcDir, "src-dir", "", "directory holding templates, styles, scripts and static assets (env SRC_DIR, default <repo>/src)")
+	flags.StringVar(&c.Env, "env", "", "\"development\" includes drafts (env ENV, default production)")
+	flags.StringVar(&c.BaseURL, "base-url", "", "absolute URL the site is deployed at (env BASE_URL, default baseURL from site.json)")
+	flags.IntVar(&c.Workers, "workers", 0, "number of articles parsed and pages rendered concurrently (env WORKERS, default number of CPUs)")
+	flags.StringVar(&c.MinifyHTML, "minify-html", "", "\"true\" or \"false\" (env MINIFY_HTML, default false in development, true otherwise)")
+	flags.StringVar(&c.PrettyURLs, "pretty-urls", "", "\"true\" writes pages to slug/index.html, linked as /slug/ (env PRETTY_URLS, default false)")
+	return &c
+}
+
+// findRepoRoot walks up from the working directory to the first directory
+// containing a go.mod. Falls back to the working directory.
+func findRepoRoot() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	for dir := wd; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			return wd
+		}
+	}
+}
+
+// firstNonEmpty returns the first non-empty string of values.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// LoadConfig produces a Config from the command line overrides, the
+// environment, site.json in the source directory, and defaults relative to
+// the repository root.
+func LoadConfig(overrides configFlags) (Config, error) {
+	root := findRepoRoot()
+
+	articleDir := firstNonEmpty(overrides.ArticleDir, os.Getenv("ARTICLE_DIR"), filepath.Join(root, "articles"))
+	outputDir := firstNonEmpty(overrides.OutputDir, os.Getenv("OUTPUT_DIR"), filepath.Join(root, "web"))
+	srcDir := firstNonEmpty(overrides.SrcDir, os.Getenv("SRC_DIR"), filepath.Join(root, "src"))
+	env := firstNonEmpty(overrides.Env, os.Getenv("ENV"), "production")
 
-	if articleDir == "" || outputDir == "" || srcDir == "" {
-		log.Fatal("ARTICLE_DIR, OUTPUT_DIR and SRC_DIR must be set")
+	site, err := loadSiteConfig(filepath.Join(srcDir, "site.json"))
+	if err != nil {
+		return Config{}, err
 	}
+	baseURL := firstNonEmpty(overrides.BaseURL, os.Getenv("BASE_URL"), site.BaseURL)
 
-	env := os.Getenv("ENV")
-	if env == "" {
-		env = "production"
+	workers := runtime.NumCPU()
+	if overrides.Workers > 0 {
+		workers = overrides.Workers
+	} else if w := os.Getenv("WORKERS"); w != "" {
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("WORKERS must be a positive integer, got %q", w)
+		}
+		workers = n
 	}
 
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "https://blog.ade-sede.dev"
+	minifyHTML := env != "development"
+	if v := firstNonEmpty(overrides.MinifyHTML, os.Getenv("MINIFY_HTML")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("MINIFY_HTML must be true or false, got %q", v)
+		}
+		minifyHTML = b
 	}
 
-	cssFiles := []string{
-		"global.css",
-		"footer.css",
-		"icons.css",
-		"navbar.css",
-		"syntax-highlighting.css",
+	prettyURLs := false
+	if v := firstNonEmpty(overrides.PrettyURLs, os.Getenv("PRETTY_URLS")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("PRETTY_URLS must be true or false, got %q", v)
+		}
+		prettyURLs = b
 	}
 
 	return Config{
@@ -54,8 +136,33 @@ func LoadConfig() Config {
 		SrcDir:     srcDir,
 		Env:        env,
 		BaseURL:    baseURL,
-		CSSFiles:   cssFiles,
-		FileFlags:  os.O_RDWR | os.O_CREATE,
 		FileMode:   0644,
+		Workers:    workers,
+		MinifyHTML: minifyHTML,
+		PrettyURLs: prettyURLs,
+		Site:       site,
+	}, nil
+}
+
+// pageFilename returns the output file of the page named name, such as
+// "articles.html": name itself, or articles/index.html with pretty URLs.
+// The home page is always index.html.
+func (c Config) pageFilename(name string) string {
+	if !c.PrettyURLs || name == "index.html" {
+		return name
+	}
+	return strings.TrimSuffix(name, ".html") + "/index.html"
+}
+
+// pageURL returns the root-relative URL of the page named name:
+// "/articles.html", or "/articles/" with pretty URLs. The home page is
+// always "/".
+func (c Config) pageURL(name string) string {
+	switch {
+	case name == "index.html":
+		return "/"
+	case c.PrettyURLs:
+		return "/" + strings.TrimSuffix(name, ".html") + "/"
 	}
+	return "/" + name
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// cspPolicy is the Content-Security-Policy of every page, in directive
+// order. The hashes of the inline scripts and styles of a page are added to
+// script-src and style-src.
+var cspPolicy = [][]string{
+	{"default-src", "'self'"},
+	{"script-src", "'self'"},
+	{"style-src", "'self'"},
+	// KaTeX positions rendered math with style attributes.
+	{"style-src-attr", "'unsafe-inline'"},
+	{"img-src", "'self'", "data:"},
+	{"font-src", "'self'", "data:"},
+	{"object-src", "'none'"},
+	{"base-uri", "'self'"},
+	{"form-action", "'self'"},
+}
+
+// metaCharsetRegex matches the <meta charset> opening <head>.
+var metaCharsetRegex = regexp.MustCompile(`^\s*<meta charset=[^>]*>`)
+
+// cspSource returns the CSP source allowing an inline element whose
+// content is text.
+func cspSource(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// inlineSources returns the CSP sources of the inline scripts and styles of
+// the page, in document order and without duplicates. Inline event handlers
+// cannot be allowed by hash and are reported as errors: attach a listener
+// from a script instead.
+func inlineSources(rendered string) (scripts, styles []string, err error) {
+	z := html.NewTokenizer(strings.NewReader(rendered))
+	var inline string // "script" or "style" while inside an inline element
+	var text strings.Builder
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return scripts, styles, nil
+			}
+			return nil, nil, z.Err()
+		case html.StartTagToken:
+			token := z.Token()
+			for _, attr := range token.Attr {
+				if strings.HasPrefix(attr.Key, "on") {
+					return nil, nil, fmt.Errorf("inline event handler %s on <%s> is not allowed by the content security policy", attr.Key, token.Data)
+				}
+			}
+			if token.Data == "style" || (token.Data == "script" && !slices.ContainsFunc(token.Attr, func(a html.Attribute) bool { return a.Key == "src" })) {
+				inline = token.Data
+				text.Reset()
+			}
+		case html.TextToken:
+			if inline != "" {
+				text.Write(z.Text())
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if inline == "" || string(name) != inline {
+				continue
+			}
+			source := cspSource(text.String())
+			if inline == "script" && !slices.Contains(scripts, source) {
+				scripts = append(scripts, source)
+			} else if inline == "style" && !slices.Contains(styles, source) {
+				styles = append(styles, source)
+			}
+			inline = ""
+		}
+	}
+}
+
+// cspGenerator adds a Content-Security-Policy <meta> tag to every page,
+// allowing exactly the inline scripts and styles the page contains. It runs
+// last since any later rewrite of an inline element would change its hash.
+type cspGenerator struct{}
+
+func (cspGenerator) Name() string { return "content security policy" }
+
+func (cspGenerator) Transform(site *Site, page Page, rendered string) (string, error) {
+	scripts, styles, err := inlineSources(rendered)
+	if err != nil {
+		return "", err
+	}
+
+	directives := make([]string, 0, len(cspPolicy))
+	for _, directive := range cspPolicy {
+		sources := directive[1:]
+		switch directive[0] {
+		case "script-src":
+			sources = append(slices.Clone(sources), scripts...)
+		case "style-src":
+			sources = append(slices.Clone(sources), styles...)
+		}
+		directives = append(directives, directive[0]+" "+strings.Join(sources, " "))
+	}
+
+	// The policy only applies to the elements following it: it must come
+	// first in <head>, after the <meta charset> that browsers look for in the
+	// first bytes of the page.
+	head := strings.Index(rendered, "<head>")
+	if head < 0 {
+		return "", fmt.Errorf("%s has no <head> to add the content security policy to", page.Filename)
+	}
+	head += len("<head>")
+	if loc := metaCharsetRegex.FindStringIndex(rendered[head:]); loc != nil {
+		head += loc[1]
+	}
+	meta := fmt.Sprintf(`<meta http-equiv="Content-Security-Policy" content="%s"/>`, strings.Join(directives, "; "))
+	return rendered[:head] + meta + rendered[head:], nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/css"
+	"golang.org/x/net/html"
+)
+
+// prunableStyleTag opens the inline <style> blocks that cssPruner may
+// reduce. assetTags only emits it for global stylesheets: article styles
+// are left alone since article scripts may add any class at runtime.
+const prunableStyleTag = "<style type='text/css' data-prune"
+
+var (
+	prunableStyleRegex = regexp.MustCompile(`(?s)<style type='text/css' data-prune(?: data-href="([^"]*)")?>(.*?)</style>`)
+
+	// dynamicPseudoRegex matches pseudo-classes that depend on user
+	// interaction and pseudo-elements, which cannot be matched against a
+	// static document: "a:hover::after" is kept whenever "a" matches.
+	dynamicPseudoRegex = regexp.MustCompile(`::?(?:hover|focus|focus-within|focus-visible|active|visited|link|target|checked|disabled|enabled|before|after|first-line|first-letter|placeholder|selection|marker|backdrop|-webkit-[-\w]+|-moz-[-\w]+)(?:\([^)]*\))?`)
+
+	// runtimeSelectorRegex matches selectors mentioning a class, id,
+	// attribute or element that scripts add to pages at runtime.
+	runtimeSelectorRegex = compileSelectorAllowlist(runtimeSelectorTokens)
+)
+
+// runtimeSelectorTokens lists what theme.js, toc.js, footnotes.js and
+// copy.js add to pages at runtime. Rules whose selector mentions one of
+// them are always kept. A trailing * matches any suffix.
+var runtimeSelectorTokens = []string{
+	// theme.js
+	".theme-button", ".theme-menu", ".theme-option", ".theme-option-name", ".theme-preview-*",
+	".open", ".active", "#theme-button-*", "#theme-menu-*",
+	"[data-theme]", "[data-current-theme]", "button", "canvas",
+	// toc.js
+	".toc-tree", ".toc-level-*", ".toc-toggle-btn", ".toc-children",
+	".fa-chevron-down", ".fa-chevron-right", "[aria-expanded]", "ul", "li",
+	// footnotes.js
+	".desktop-hidden", ".footnote-item", ".show", "[data-footnote]",
+	// copy.js
+	".copy-notification",
+}
+
+// compileSelectorAllowlist turns selector tokens into a regexp matching
+// any selector that mentions one of them.
+func compileSelectorAllowlist(tokens []string) *regexp.Regexp {
+	var alternatives []string
+	for _, token := range tokens {
+		name := strings.TrimSuffix(strings.Trim(token, ".#[]"), "*")
+		pattern := regexp.QuoteMeta(name)
+		if strings.HasSuffix(token, "*") {
+			pattern += `[-\w]*`
+		}
+		switch token[0] {
+		case '.', '#':
+			pattern = regexp.QuoteMeta(token[:1]) + pattern
+		case '[':
+			pattern = `\[\s*` + pattern
+		default:
+			pattern = `(?:^|[\s>+~(,])` + pattern
+		}
+		alternatives = append(alternatives, pattern+`(?:[^-\w]|$)`)
+	}
+	return regexp.MustCompile(strings.Join(alternatives, "|"))
+}
+
+// selectorUsed reports whether a rule with the given selector may apply to
+// doc. Whenever in doubt, for selectors the matcher does not support or
+// elements added at runtime, it errs on the side of keeping the rule.
+func selectorUsed(selector string, doc *html.Node) bool {
+	if runtimeSelectorRegex.MatchString(selector) {
+		return true
+	}
+	static := strings.TrimSpace(dynamicPseudoRegex.ReplaceAllString(selector, ""))
+	if static == "" {
+		return true
+	}
+	compiled, err := cascadia.Compile(static)
+	if err != nil {
+		return true
+	}
+	return compiled.MatchFirst(doc) != nil
+}
+
+// cssBlock is an at-rule block being written, or the whole stylesheet.
+type cssBlock struct {
+	header   string // at-rule name and prelude, empty for the stylesheet
+	body     bytes.Buffer
+	prunable bool // holds style rules that may be pruned
+	kept     int  // number of rules written to body
+}
+
+// tokensString concatenates the text of tokens.
+func tokensString(tokens []css.Token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.Write(t.Data)
+	}
+	return b.String()
+}
+
+// pruneCSS returns the rules of stylesheet src that may apply to doc.
+// Selectors of a rule that match nothing are dropped, rules left without
+// selectors are removed, and so are @media and @supports blocks left
+// empty. Other at-rules, such as @keyframes and @font-face, are kept.
+func pruneCSS(src string, doc *html.Node) (string, error) {
+	p := css.NewParser(parse.NewInputString(src), false)
+	blocks := []*cssBlock{{prunable: true}}
+	var selectors []string
+	skipping := false
+
+	for {
+		gt, _, data := p.Next()
+		current := blocks[len(blocks)-1]
+		switch gt {
+		case css.ErrorGrammar:
+			if p.Err() == io.EOF {
+				return blocks[0].body.String(), nil
+			}
+			return "", p.Err()
+		case css.AtRuleGrammar:
+			fmt.Fprintf(&current.body, "%s%s;", data, tokensString(p.Values()))
+			current.kept++
+		case css.BeginAtRuleGrammar:
+			name := string(data)
+			prelude := tokensString(p.Values())
+			if prelude != "" {
+				prelude = " " + prelude
+			}
+			blocks = append(blocks, &cssBlock{
+				header:   name + prelude,
+				prunable: name == "@media" || name == "@supports",
+			})
+		case css.EndAtRuleGrammar:
+			blocks = blocks[:len(blocks)-1]
+			if current.prunable && current.kept == 0 {
+				continue
+			}
+			parent := blocks[len(blocks)-1]
+			fmt.Fprintf(&parent.body, "%s{%s}", current.header, current.body.String())
+			parent.kept++
+		case css.QualifiedRuleGrammar:
+			selectors = append(selectors, tokensString(p.Values()))
+		case css.BeginRulesetGrammar:
+			selectors = append(selectors, tokensString(p.Values()))
+			used := selectors
+			if current.prunable {
+				used = nil
+				for _, selector := range selectors {
+					if selectorUsed(selector, doc) {
+						used = append(used, selector)
+					}
+				}
+			}
+			selectors = nil
+			skipping = len(used) == 0
+			if !skipping {
+				fmt.Fprintf(&current.body, "%s{", strings.Join(used, ","))
+			}
+		case css.DeclarationGrammar, css.CustomPropertyGrammar:
+			if !skipping {
+				fmt.Fprintf(&current.body, "%s:%s;", data, tokensString(p.Values()))
+			}
+		case css.EndRulesetGrammar:
+			if !skipping {
+				current.body.WriteString("}")
+				current.kept++
+			}
+			skipping = false
+		case css.TokenGrammar:
+			current.body.Write(data)
+		}
+	}
+}
+
+// prunableStyle returns the <style> block of the global stylesheet css for
+// cssPruner. A stylesheet in AssetAuto delivery carries href, its published
+// path: whether it is inlined or linked is decided once pruned.
+func prunableStyle(css, href string) string {
+	if href == "" {
+		return prunableStyleTag + ">" + css + "</style>"
+	}
+	return fmt.Sprintf(`%s data-href="%s">%s</style>`, prunableStyleTag, href, css)
+}
+
+// cssPruner reduces the global stylesheets inlined in a page to the rules
+// that may apply to it, and keeps track of the bytes saved. A stylesheet
+// carrying its published path is inlined if its pruned rules fit in the
+// assetInlineLimit of site.json, and linked otherwise.
+type cssPruner struct {
+	before atomic.Int64
+	after  atomic.Int64
+	linked atomic.Int64
+}
+
+func (*cssPruner) Name() string { return "css pruning" }
+
+func (c *cssPruner) Transform(site *Site, page Page, rendered string) (string, error) {
+	if !strings.Contains(rendered, prunableStyleTag) {
+		return rendered, nil
+	}
+	doc, err := html.Parse(strings.NewReader(rendered))
+	if err != nil {
+		return "", err
+	}
+
+	var pruneErr error
+	result := prunableStyleRegex.ReplaceAllStringFunc(rendered, func(match string) string {
+		parts := prunableStyleRegex.FindStringSubmatch(match)
+		href, src := parts[1], parts[2]
+		pruned, err := pruneCSS(src, doc)
+		if err != nil {
+			pruneErr = err
+			return match
+		}
+		if href != "" && len(pruned) > site.Config.Site.AssetInlineLimit {
+			c.linked.Add(1)
+			return fmt.Sprintf(`<link rel="stylesheet" href="%s"/>`, href)
+		}
+		c.before.Add(int64(len(src)))
+		c.after.Add(int64(len(pruned)))
+		return "<style type='text/css'>" + pruned + "</style>"
+	})
+	if pruneErr != nil {
+		return "", pruneErr
+	}
+	return result, nil
+}
+
+// Report returns the bytes of inline CSS saved since the last report, and
+// the number of stylesheets linked because they were too large once pruned.
+func (c *cssPruner) Report() string {
+	before, after, linked := c.before.Swap(0), c.after.Swap(0), c.linked.Swap(0)
+	if before == 0 && linked == 0 {
+		return ""
+	}
+	report := "no inline CSS"
+	if before > 0 {
+		report = fmt.Sprintf("%.1f KiB -> %.1f KiB of inline CSS (-%.1f%%)",
+			float64(before)/1024, float64(after)/1024, 100*float64(before-after)/float64(before))
+	}
+	if linked > 0 {
+		report += fmt.Sprintf(", %d stylesheets linked", linked)
+	}
+	return report
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Position locates a diagnostic in a source file. Line and Column are
+// 1-based; zero means unknown.
+type Position struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// String formats p as file:line:col, omitting unknown parts. Files below
+// the working directory are shown relative to it.
+func (p Position) String() string {
+	file := p.File
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+		}
+	}
+	switch {
+	case p.Line > 0 && p.Column > 0:
+		return fmt.Sprintf("%s:%d:%d", file, p.Line, p.Column)
+	case p.Line > 0:
+		return fmt.Sprintf("%s:%d", file, p.Line)
+	default:
+		return file
+	}
+}
+
+// Diagnostic is a problem found in a source file. It implements error so
+// that parsing helpers can return a located error.
+type Diagnostic struct {
+	Position
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Error formats the diagnostic the way compilers do:
+// "articles/foo.md:12:3: error: message".
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s: %s", d.Position, d.Severity, d.Message)
+}
+
+// Diagnostics collects the errors and warnings of a build so that every
+// problem is reported at once instead of stopping at the first one. A
+// nil *Diagnostics discards everything. All methods are safe for
+// concurrent use.
+type Diagnostics struct {
+	mu    sync.Mutex
+	items []Diagnostic
+}
+
+// Add records d.
+func (ds *Diagnostics) Add(d Diagnostic) {
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.items = append(ds.items, d)
+}
+
+// Errorf records an error at pos.
+func (ds *Diagnostics) Errorf(pos Position, format string, args ...any) {
+	ds.Add(Diagnostic{Position: pos, Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf records a warning at pos.
+func (ds *Diagnostics) Warnf(pos Position, format string, args ...any) {
+	ds.Add(Diagnostic{Position: pos, Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// AddError records err as an error. A Diagnostic keeps its own position;
+// any other error is attributed to file.
+func (ds *Diagnostics) AddError(file string, err error) {
+	var d Diagnostic
+	if errors.As(err, &d) {
+		ds.Add(d)
+		return
+	}
+	ds.Errorf(Position{File: file}, "%v", err)
+}
+
+// Items returns the recorded diagnostics sorted by file, line and column.
+func (ds *Diagnostics) Items() []Diagnostic {
+	if ds == nil {
+		return nil
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	items := append([]Diagnostic(nil), ds.items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return items
+}
+
+// count returns the number of diagnostics of the given severity.
+func (ds *Diagnostics) count(severity Severity) int {
+	if ds == nil {
+		return 0
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	n := 0
+	for _, d := range ds.items {
+		if d.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// HasErrors reports whether at least one error was recorded.
+func (ds *Diagnostics) HasErrors() bool {
+	return ds.count(SeverityError) > 0
+}
+
+// Err returns the recorded errors joined into one, or nil when there is
+// none. Warnings are left out.
+func (ds *Diagnostics) Err() error {
+	var errs []error
+	for _, d := range ds.Items() {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Summary returns e.g. "2 errors, 1 warning".
+func (ds *Diagnostics) Summary() string {
+	plural := func(n int, word string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, word)
+		}
+		return fmt.Sprintf("%d %ss", n, word)
+	}
+	return plural(ds.count(SeverityError), "error") + ", " + plural(ds.count(SeverityWarning), "warning")
+}
+
+// Print writes every diagnostic to w, one per line, followed by a summary
+// line when there is anything to report.
+func (ds *Diagnostics) Print(w io.Writer) {
+	items := ds.Items()
+	if len(items) == 0 {
+		return
+	}
+	for _, d := range items {
+		fmt.Fprintln(w, d.Error())
+	}
+	fmt.Fprintln(w, ds.Summary())
+}
+
+// offsetPosition converts a byte offset in source into a Position in file.
+// Columns count bytes, like most compilers.
+func offsetPosition(file string, source []byte, offset int) Position {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	line := 1 + bytes.Count(source[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(source[:offset], '\n')
+	return Position{File: file, Line: line, Column: column}
+}
+
+// jsonErrorPosition locates a json.Unmarshal error in content. Syntax and
+// type errors carry an offset; other errors are attributed to the file.
+func jsonErrorPosition(file string, content []byte, err error) Position {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return offsetPosition(file, content, int(syntaxErr.Offset))
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return offsetPosition(file, content, int(typeErr.Offset))
+	}
+	return Position{File: file}
+}
+
+// jsonKeyPosition returns the position of the value of the top-level key in
+// the JSON object content, or the start of the file when the key is absent.
+func jsonKeyPosition(file string, content []byte, key string) Position {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return Position{File: file}
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if tok == key {
+			// Skip the colon and whitespace between the key and its value.
+			offset := int(dec.InputOffset())
+			for offset < len(content) && strings.ContainsRune(": \t\r\n", rune(content[offset])) {
+				offset++
+			}
+			return offsetPosition(file, content, offset)
+		}
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			break
+		}
+	}
+	return Position{File: file}
+}
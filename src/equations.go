@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Display math is numbered by labelling it, and referenced from the text
+// of the article by its label:
+//
+//	\[ E = mc^2 \label{eq:energy} \]
+//
+//	As \eqref{eq:energy} shows, and @eq:energy again...
+//
+// Labelled equations are numbered from 1 in the order of the article, the
+// number being shown by KaTeX as a \tag, and references become links to
+// the equation reading (1). KaTeX knows neither \label nor \eqref: both
+// are handled here. Unknown and duplicate labels are errors.
+
+var (
+	kindEquationRef = ast.NewNodeKind("EquationRef")
+
+	equationLabelRegex = regexp.MustCompile(`\\label\s*\{([^{}]*)\}`)
+	equationRefRegex   = regexp.MustCompile(`^(?:\\eqref\{([^{}]*)\}|@(eq:[\w:.\-]*\w))`)
+)
+
+// equation is the numbering of a math node, set by equationNumberer.
+type equation struct {
+	Label  string // argument of the \label of the expression
+	Number int    // 0 when the expression is not numbered
+}
+
+// equationRef is a reference to a labelled equation in the text:
+// \eqref{label} or @eq:label.
+type equationRef struct {
+	ast.BaseInline
+	Label  string
+	Start  int // offset of the reference in the source
+	Number int // 0 when the label is unknown
+}
+
+func (n *equationRef) Kind() ast.NodeKind { return kindEquationRef }
+
+func (n *equationRef) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Label":  n.Label,
+		"Number": fmt.Sprint(n.Number),
+	}, nil)
+}
+
+// equationRefParser parses references to equations. A @eq: reference
+// must not follow a letter or digit, as the @ of an email address does.
+type equationRefParser struct{}
+
+func (p *equationRefParser) Trigger() []byte {
+	return []byte{'\\', '@'}
+}
+
+func (p *equationRefParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	match := equationRefRegex.FindSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	if line[0] == '@' {
+		if c := block.PrecendingCharacter(); unicode.IsLetter(c) || unicode.IsDigit(c) {
+			return nil
+		}
+	}
+	label := string(match[1]) + string(match[2])
+	block.Advance(len(match[0]))
+	return &equationRef{Label: strings.TrimSpace(label), Start: segment.Start}
+}
+
+// equationNumberer is a Goldmark AST transformer that numbers the labelled
+// display math of the document, and resolves the references to it.
+type equationNumberer struct {
+	filename string
+	diags    *Diagnostics
+}
+
+func (t *equationNumberer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	lines := make(map[string]int) // label to the line it is defined on
+	numbers := make(map[string]int)
+	var refs []*equationRef
+
+	// label numbers the expression held in segments when it has a \label.
+	label := func(eq *equation, display bool, segments []text.Segment) {
+		for _, segment := range segments {
+			value := segment.Value(source)
+			for _, loc := range equationLabelRegex.FindAllSubmatchIndex(value, -1) {
+				pos := offsetPosition(t.filename, source, segment.Start+loc[0])
+				name := strings.TrimSpace(string(value[loc[2]:loc[3]]))
+				switch {
+				case !display:
+					t.diags.Errorf(pos, "\\label in inline math: only display math is numbered")
+				case name == "" || strings.ContainsFunc(name, unicode.IsSpace):
+					t.diags.Errorf(pos, "invalid equation label %q: labels are not empty and have no spaces", name)
+				case eq.Label != "":
+					t.diags.Errorf(pos, "equation already labelled %q", eq.Label)
+				case lines[name] != 0:
+					t.diags.Errorf(pos, "duplicate equation label %q, first defined on line %d", name, lines[name])
+				default:
+					eq.Label, eq.Number = name, len(numbers)+1
+					lines[name], numbers[name] = pos.Line, eq.Number
+				}
+			}
+		}
+	}
+
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *mathInline:
+			label(&n.equation, n.Display, []text.Segment{n.Value})
+		case *mathBlock:
+			label(&n.equation, true, n.Lines().Sliced(0, n.Lines().Len()))
+		case *equationRef:
+			refs = append(refs, n)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// References are resolved once every equation is numbered: they may
+	// come before the equation.
+	for _, ref := range refs {
+		number, ok := numbers[ref.Label]
+		if !ok {
+			t.diags.Errorf(offsetPosition(t.filename, source, ref.Start), "unknown equation label %q", ref.Label)
+			continue
+		}
+		ref.Number = number
+	}
+}
+
+// renderEquationRef renders a reference as a link to the equation. An
+// unknown label, reported by equationNumberer, reads (??) as in LaTeX.
+func (r *mathRenderer) renderEquationRef(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*equationRef)
+	if n.Number == 0 {
+		w.WriteString("(??)")
+		return ast.WalkSkipChildren, nil
+	}
+	fmt.Fprintf(w, `<a href="#%s">(%d)</a>`, util.EscapeHTML([]byte(n.Label)), n.Number)
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalLinkCacheFilename is the location of the results of previous
+// external link checks, relative to the output directory.
+const externalLinkCacheFilename = ".cache/external-links.json"
+
+// ExternalLink is an http(s) URL referenced by an article, and where.
+type ExternalLink struct {
+	URL      string
+	Position Position
+}
+
+// isExternalURL reports whether u is an absolute http(s) URL.
+func isExternalURL(u string) bool {
+	return strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://")
+}
+
+// externalResult is the outcome of checking a URL.
+type externalResult struct {
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// ok reports whether the URL resolved, possibly through redirects.
+func (r externalResult) ok() bool {
+	return r.Error == "" && r.Status < 400
+}
+
+// unverified reports whether the server refused to answer the checker
+// rather than reported the URL missing: many sites turn away clients that
+// are not browsers, or that come back too often.
+func (r externalResult) unverified() bool {
+	return r.Status == http.StatusUnauthorized || r.Status == http.StatusForbidden || r.Status == http.StatusTooManyRequests
+}
+
+// String describes the result for a report.
+func (r externalResult) String() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	return fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status))
+}
+
+// externalLinkChecker checks external URLs over HTTP.
+type externalLinkChecker struct {
+	Client *http.Client
+	// BaseURL, when set, replaces the scheme and host of every URL:
+	// https://example.com/a?b is requested as <BaseURL>/example.com/a?b.
+	// It points the checker at a local stand-in server.
+	BaseURL  string
+	Workers  int
+	Interval time.Duration // minimum delay between requests to a host
+	Retries  int           // retries of network errors, 429 and 5xx
+	TTL      time.Duration // how long a successful result is reused
+
+	mu   sync.Mutex
+	next map[string]time.Time // host -> earliest time of the next request
+}
+
+// externalLinkCache holds the results of previous checks, keyed by URL. It
+// is persisted in <OutputDir>/.cache/external-links.json.
+type externalLinkCache struct {
+	Results map[string]externalResult `json:"results"`
+
+	path string
+}
+
+// loadExternalLinkCache reads the cache from outputDir. A missing or
+// unreadable cache is treated as empty.
+func loadExternalLinkCache(outputDir string) *externalLinkCache {
+	cache := &externalLinkCache{path: filepath.Join(outputDir, externalLinkCacheFilename)}
+	if content, err := os.ReadFile(cache.path); err == nil {
+		json.Unmarshal(content, cache)
+	}
+	if cache.Results == nil {
+		cache.Results = make(map[string]externalResult)
+	}
+	return cache
+}
+
+// save writes the cache to disk.
+func (c *externalLinkCache) save() error {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal external link cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, content, 0644)
+}
+
+// requestURL returns the URL actually requested for the external URL
+// rawURL.
+func (c *externalLinkChecker) requestURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if c.BaseURL == "" || err != nil {
+		return rawURL
+	}
+	rewritten := *u
+	rewritten.Scheme, rewritten.Host, rewritten.User = "", "", nil
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + u.Host + rewritten.String()
+}
+
+// wait blocks until a request to host respects the interval between
+// requests to it.
+func (c *externalLinkChecker) wait(host string) {
+	c.mu.Lock()
+	if c.next == nil {
+		c.next = make(map[string]time.Time)
+	}
+	now := time.Now()
+	at := c.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	c.next[host] = at.Add(c.Interval)
+	c.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// fetch requests target once, with HEAD and then with GET for servers that
+// do not support HEAD. It returns the status and the delay asked for by a
+// Retry-After header.
+func (c *externalLinkChecker) fetch(host, target string) (int, time.Duration, error) {
+	var resp *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; blog-link-checker)")
+		c.wait(host)
+		resp, err = c.Client.Do(req)
+		if err != nil {
+			return 0, 0, err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return resp.StatusCode, retryAfter, nil
+}
+
+// check requests rawURL, retrying network errors, 429 and 5xx responses
+// with exponential backoff.
+func (c *externalLinkChecker) check(rawURL string) externalResult {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return externalResult{Error: fmt.Sprintf("malformed URL: %v", err), CheckedAt: time.Now()}
+	}
+	target := c.requestURL(rawURL)
+
+	var result externalResult
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		status, retryAfter, err := c.fetch(u.Host, target)
+		result = externalResult{Status: status, CheckedAt: time.Now()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		retryable := err != nil || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= c.Retries {
+			return result
+		}
+		delay := max(backoff, min(retryAfter, time.Minute))
+		time.Sleep(delay)
+		backoff *= 2
+	}
+}
+
+// checkExternalLinks checks every external URL referenced by articles and
+// reports the broken ones in diags, at each place they are referenced.
+// Results are cached in outputDir by requested URL, so that checks against
+// a stand-in server do not mix with real ones; successful results are
+// reused until they are older than the TTL of the checker. It prints a
+// summary to w.
+func checkExternalLinks(c *externalLinkChecker, articles []Article, outputDir string, w io.Writer, diags *Diagnostics) error {
+	uses := make(map[string][]Position)
+	for _, a := range articles {
+		for _, link := range a.ExternalLinks {
+			uses[link.URL] = append(uses[link.URL], link.Position)
+		}
+	}
+	urls := make([]string, 0, len(uses))
+	for u := range uses {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	cache := loadExternalLinkCache(outputDir)
+	results := make(map[string]externalResult, len(urls))
+	var todo []string
+	for _, u := range urls {
+		if cached, ok := cache.Results[c.requestURL(u)]; ok && cached.ok() && time.Since(cached.CheckedAt) < c.TTL {
+			results[u] = cached
+		} else {
+			todo = append(todo, u)
+		}
+	}
+
+	checked := make([]externalResult, len(todo))
+	forEachParallel(len(todo), c.Workers, func(i int) error {
+		checked[i] = c.check(todo[i])
+		return nil
+	})
+	for i, u := range todo {
+		results[u] = checked[i]
+		cache.Results[c.requestURL(u)] = checked[i]
+	}
+
+	var broken, unverified int
+	for _, u := range urls {
+		result := results[u]
+		if result.ok() {
+			continue
+		}
+		for _, pos := range uses[u] {
+			if result.unverified() {
+				diags.Warnf(pos, "external link %s could not be verified: %s", u, result)
+			} else {
+				diags.Errorf(pos, "broken external link %s: %s", u, result)
+			}
+		}
+		if result.unverified() {
+			unverified++
+		} else {
+			broken++
+		}
+	}
+	fmt.Fprintf(w, "%d external links: %d checked, %d cached, %d broken, %d unverified\n",
+		len(urls), len(todo), len(urls)-len(todo), broken, unverified)
+
+	for key, result := range cache.Results {
+		if time.Since(result.CheckedAt) >= c.TTL {
+			delete(cache.Results, key)
+		}
+	}
+	return cache.save()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn is a stand-in server for external sites, reached through the
+// BaseURL of the checker. It answers each request with handle, and records
+// the requests it received.
+type standIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []standInRequest
+}
+
+type standInRequest struct {
+	Method string
+	Path   string
+	At     time.Time
+}
+
+func newStandIn(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, previous []standInRequest)) *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		previous := s.requestsTo(r.URL.Path)
+		s.requests = append(s.requests, standInRequest{Method: r.Method, Path: r.URL.Path, At: time.Now()})
+		s.mu.Unlock()
+		handle(w, r, previous)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requestsTo returns the requests received for path. The caller holds mu.
+func (s *standIn) requestsTo(path string) []standInRequest {
+	var requests []standInRequest
+	for _, r := range s.requests {
+		if r.Path == path {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// snapshot returns the requests received so far.
+func (s *standIn) snapshot() []standInRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *standIn) checker() *externalLinkChecker {
+	return &externalLinkChecker{
+		Client:  s.Client(),
+		BaseURL: s.URL,
+		Workers: 4,
+		Retries: 2,
+		TTL:     time.Hour,
+	}
+}
+
+func TestExternalLinkCheckRetries(t *testing.T) {
+	server := newStandIn(t, func(w http.ResponseWriter, r *http.Request, previous []standInRequest) {
+		switch r.URL.Path {
+		case "/example.com/flaky":
+			if len(previous) < 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/example.com/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		case "/example.com/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	})
+
+	tests := []struct {
+		url      string
+		status   int
+		requests int
+	}{
+		{"https://example.com/flaky", http.StatusOK, 3},
+		{"https://example.com/limited", http.StatusTooManyRequests, 3},
+		{"https://example.com/missing", http.StatusNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			result := server.checker().check(tt.url)
+			if result.Status != tt.status || result.Error != "" {
+				t.Errorf("check = %s, want %d", result, tt.status)
+			}
+			path := strings.TrimPrefix(tt.url, "https:/")
+			got := 0
+			for _, r := range server.snapshot() {
+				if r.Path == path {
+					got++
+				}
+			}
+			if got != tt.requests {
+				t.Errorf("%d requests to %s, want %d", got, path, tt.requests)
+			}
+		})
+	}
+}
+
+func TestExternalLinkCheckRetryAfter(t *testing.T) {
+	server := newStandIn(t, func(w http.ResponseWriter, r *http.Request, previous []standInRequest) {
+		if len(previous) == 0 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+
+	start := time.Now()
+	result := server.checker().check("https://example.com/page")
+	if !result.ok() {
+		t.Fatalf("check = %s, want 200", result)
+	}
+	// The backoff alone would retry after 500ms.
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %v, want the 2s of Retry-After", elapsed)
+	}
+}
+
+func TestExternalLinkCheckHostInterval(t *testing.T) {
+	server := newStandIn(t, func(w http.ResponseWriter, r *http.Request, previous []standInRequest) {})
+	checker := server.checker()
+	checker.Interval = 200 * time.Millisecond
+
+	var links []ExternalLink
+	for _, u := range []string{"https://a.example/1", "https://a.example/2", "https://a.example/3", "https://b.example/1"} {
+		links = append(links, ExternalLink{URL: u})
+	}
+	diags := &Diagnostics{}
+	if err := checkExternalLinks(checker, []Article{{ExternalLinks: links}}, t.TempDir(), io.Discard, diags); err != nil {
+		t.Fatal(err)
+	}
+	if diags.HasErrors() {
+		t.Fatalf("unexpected diagnostics: %s", diags.Summary())
+	}
+
+	first := make(map[string]time.Time) // host -> time of its first request
+	var last time.Time                  // time of the last request to a.example
+	for _, r := range server.snapshot() {
+		host := strings.Split(r.Path, "/")[1]
+		if _, ok := first[host]; !ok {
+			first[host] = r.At
+		}
+		if host != "a.example" {
+			continue
+		}
+		if !last.IsZero() && r.At.Sub(last) < checker.Interval-10*time.Millisecond {
+			t.Errorf("requests to a.example %v apart, want at least %v", r.At.Sub(last), checker.Interval)
+		}
+		last = r.At
+	}
+	if gap := first["b.example"].Sub(first["a.example"]); gap >= checker.Interval {
+		t.Errorf("b.example waited %v for a.example", gap)
+	}
+}
+
+func TestExternalLinkCheckGetFallback(t *testing.T) {
+	server := newStandIn(t, func(w http.ResponseWriter, r *http.Request, previous []standInRequest) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	result := server.checker().check("https://example.com/page")
+	if !result.ok() {
+		t.Fatalf("check = %s, want 200", result)
+	}
+	var methods []string
+	for _, r := range server.snapshot() {
+		methods = append(methods, r.Method)
+	}
+	if got := strings.Join(methods, " "); got != "HEAD GET" {
+		t.Errorf("methods = %s, want HEAD GET", got)
+	}
+}
+
+func TestExternalLinkCacheTTL(t *testing.T) {
+	server := newStandIn(t, func(w http.ResponseWriter, r *http.Request, previous []standInRequest) {
+		if r.URL.Path == "/example.com/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	outputDir := t.TempDir()
+	articles := []Article{{ExternalLinks: []ExternalLink{
+		{URL: "https://example.com/page"},
+		{URL: "https://example.com/missing"},
+	}}}
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		summary string
+	}{
+		{"first check", time.Hour, "2 external links: 2 checked, 0 cached, 1 broken, 0 unverified\n"},
+		// The broken link is checked again, the working one is not.
+		{"within the TTL", time.Hour, "2 external links: 1 checked, 1 cached, 1 broken, 0 unverified\n"},
+		{"past the TTL", time.Nanosecond, "2 external links: 2 checked, 0 cached, 1 broken, 0 unverified\n"},
+	}
+	for _, tt := range tests {
+		checker := server.checker()
+		checker.TTL = tt.ttl
+		var summary strings.Builder
+		if err := checkExternalLinks(checker, articles, outputDir, &summary, &Diagnostics{}); err != nil {
+			t.Fatal(err)
+		}
+		if summary.String() != tt.summary {
+			t.Errorf("%s: summary = %q, want %q", tt.name, summary.String(), tt.summary)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+var (
+	// responsiveImageWidths are the widths images are resized to, when
+	// narrower than the original.
+	responsiveImageWidths = []int{320, 640, 960, 1280}
+
+	// articleImageMaxWidth is the widest an image is displayed at: the
+	// max-width of .article in article.css.
+	articleImageMaxWidth = 900
+)
+
+// imageVariantCacheDir holds the resized variants of images, named after
+// the hash of their source and width, relative to the output directory.
+// Unlike the build cache, it survives --force.
+const imageVariantCacheDir = ".cache/images"
+
+// ArticleImage is a local PNG or JPEG image referenced by an article.
+type ArticleImage struct {
+	Logical string // published path, e.g. "images/foo.png" or "slug/images/foo.png" in a bundle
+	Src     string
+	Hash    string
+	Width   int
+	Height  int
+	Widths  []int // widths of its resized variants, narrowest first
+}
+
+// readArticleImage reads the dimensions of the image at logical path p,
+// relative to root.
+func readArticleImage(root, p string) (ArticleImage, error) {
+	src := filepath.Join(root, filepath.FromSlash(p))
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return ArticleImage{}, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return ArticleImage{}, err
+	}
+	return ArticleImage{
+		Logical: p,
+		Src:     src,
+		Hash:    hashBytes(content),
+		Width:   config.Width,
+		Height:  config.Height,
+	}, nil
+}
+
+// articleImagesUpToDate reports whether none of images changed since they
+// were read.
+func articleImagesUpToDate(images []ArticleImage) bool {
+	for _, img := range images {
+		content, err := os.ReadFile(img.Src)
+		if err != nil || hashBytes(content) != img.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// candidateWidths returns the widths img may be resized to: those of
+// responsiveImageWidths narrower than it.
+func (img ArticleImage) candidateWidths() []int {
+	// Commas and spaces separate the candidates of a srcset.
+	if strings.ContainsAny(img.Logical, ", ") {
+		return nil
+	}
+	var widths []int
+	for _, width := range responsiveImageWidths {
+		if width < img.Width {
+			widths = append(widths, width)
+		}
+	}
+	return widths
+}
+
+// variantReference returns the reference to the variant resized to width
+// of the image at p: images/foo.png -> images/foo-320w.png.
+func variantReference(p string, width int) string {
+	ext := path.Ext(p)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(p, ext), width, ext)
+}
+
+// variantPath returns the logical path of the variant of img resized to
+// width.
+func (img ArticleImage) variantPath(width int) string {
+	return variantReference(img.Logical, width)
+}
+
+// srcset returns the srcset of img referenced as ref, from its narrowest
+// variant to the original, or "" when it has no variant. Candidates are
+// written like ref, so that they are rewritten along with it.
+func (img ArticleImage) srcset(ref string) string {
+	if len(img.Widths) == 0 {
+		return ""
+	}
+	candidates := make([]string, 0, len(img.Widths)+1)
+	for _, width := range img.Widths {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", variantReference(ref, width), width))
+	}
+	candidates = append(candidates, fmt.Sprintf("%s %dw", ref, img.Width))
+	return strings.Join(candidates, ", ")
+}
+
+// sizes returns the sizes attribute of img: the full viewport width on
+// small screens, its displayed width otherwise.
+func (img ArticleImage) sizes() string {
+	width := min(img.Width, articleImageMaxWidth)
+	return fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", width, width)
+}
+
+// imageResolver reads the local images referenced by an article, so that
+// they are rendered with their intrinsic size and resized variants, and
+// records them so that the variants get published. The references of a
+// bundle are relative to its directory in articleDir. Variants are resized
+// into variantDir, the imageVariantCacheDir of the output directory.
+type imageResolver struct {
+	srcDir     string
+	articleDir string
+	variantDir string
+	bundle     string
+	filename   string
+	diags      *Diagnostics
+	images     []ArticleImage
+}
+
+// resolve returns the local PNG or JPEG image the reference dest points
+// to. A missing image is left to the link checker.
+func (r *imageResolver) resolve(dest string) (ArticleImage, bool) {
+	if strings.ContainsAny(dest, ":?#") {
+		return ArticleImage{}, false
+	}
+	logical := path.Clean(strings.TrimPrefix(dest, "/"))
+	if r.bundle != "" && !strings.HasPrefix(dest, "/") {
+		logical = path.Join(r.bundle, logical)
+	}
+	if strings.HasPrefix(logical, "../") {
+		return ArticleImage{}, false
+	}
+	root := r.srcDir
+	if r.bundle != "" && strings.HasPrefix(logical, r.bundle+"/") {
+		root = r.articleDir
+	}
+	switch strings.ToLower(path.Ext(logical)) {
+	case ".png", ".jpg", ".jpeg":
+	default:
+		return ArticleImage{}, false
+	}
+
+	for _, img := range r.images {
+		if img.Logical == logical {
+			return img, true
+		}
+	}
+	img, err := readArticleImage(root, logical)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			r.diags.Warnf(Position{File: r.filename}, "cannot read image %s: %v", dest, err)
+		}
+		return ArticleImage{}, false
+	}
+	if img.Widths, err = lighterVariantWidths(img, r.variantDir); err != nil {
+		r.diags.Warnf(Position{File: r.filename}, "cannot resize image %s: %v", dest, err)
+	}
+	r.images = append(r.images, img)
+	return img, true
+}
+
+// attributes returns the attributes completing the <img> tag of dest: its
+// intrinsic size, which reserves its room in the layout before it loads,
+// and the srcset and sizes letting the browser pick the smallest variant
+// that fits.
+func (r *imageResolver) attributes(dest string) string {
+	img, ok := r.resolve(dest)
+	if !ok {
+		return ""
+	}
+	attrs := fmt.Sprintf(` width="%d" height="%d"`, img.Width, img.Height)
+	if srcset := img.srcset(dest); srcset != "" {
+		attrs += fmt.Sprintf(` srcset="%s" sizes="%s"`, srcset, img.sizes())
+	}
+	return attrs
+}
+
+// resizeImage returns the image at src scaled to width, in its original
+// format.
+func resizeImage(src string, width int) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	img, format, err := image.Decode(in)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	height := max(1, bounds.Dy()*width/bounds.Dx())
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, resized)
+	case "jpeg":
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+	default:
+		err = fmt.Errorf("unsupported image format %s", format)
+	}
+	return buf.Bytes(), err
+}
+
+// imageVariant is a resized copy of an article image.
+type imageVariant struct {
+	Image ArticleImage
+	Width int
+}
+
+// hash identifies the content of the variant.
+func (v imageVariant) hash() string {
+	return hashBytes([]byte(v.Image.Hash), []byte(strconv.Itoa(v.Width)))
+}
+
+// cacheFilename returns the name of the variant in imageVariantCacheDir.
+func (v imageVariant) cacheFilename() string {
+	return v.hash()[:16] + path.Ext(v.Image.Logical)
+}
+
+// resize returns the file of the variant in dir, resizing the image into
+// it unless it already is there.
+func (v imageVariant) resize(dir string) (string, error) {
+	cached := filepath.Join(dir, v.cacheFilename())
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	resized, err := resizeImage(v.Image.Src, v.Width)
+	if err != nil {
+		return "", fmt.Errorf("failed to resize %s: %v", v.Image.Src, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// Articles sharing an image may resize it at the same time: the
+	// variant only appears once written.
+	tmp, err := os.CreateTemp(dir, ".resize-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(resized); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return cached, os.Rename(tmp.Name(), cached)
+}
+
+// lighterVariantWidths resizes img into dir to each of its candidate widths,
+// and returns those whose variant is lighter than the original. Smoothing
+// adds colors to flat graphics, which PNG compresses worse: a heavier
+// variant is left out of the srcset, the original serving that width.
+func lighterVariantWidths(img ArticleImage, dir string) ([]int, error) {
+	original, err := os.Stat(img.Src)
+	if err != nil {
+		return nil, err
+	}
+	var widths []int
+	for _, width := range img.candidateWidths() {
+		cached, err := imageVariant{Image: img, Width: width}.resize(dir)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(cached)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() < original.Size() {
+			widths = append(widths, width)
+		}
+	}
+	return widths, nil
+}
+
+// publishImageVariants stages the resized variants of the images of
+// articles in out and adds them to manifest. Variants are resized once per
+// source content and width, when articles are parsed, and kept in
+// imageVariantCacheDir; variants no longer used, including the heavier ones
+// left out, are removed from it.
+func publishImageVariants(config Config, out *outputWriter, cache *buildCache, articles []Article, manifest AssetManifest) error {
+	var variants []imageVariant
+	used := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, a := range articles {
+		for _, img := range a.Images {
+			if seen[img.Logical] {
+				continue
+			}
+			seen[img.Logical] = true
+			for _, width := range img.Widths {
+				variants = append(variants, imageVariant{Image: img, Width: width})
+			}
+			for _, width := range img.candidateWidths() {
+				used[imageVariant{Image: img, Width: width}.cacheFilename()] = true
+			}
+		}
+	}
+
+	cacheDir := filepath.Join(config.OutputDir, filepath.FromSlash(imageVariantCacheDir))
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	publishedPaths := make([]string, len(variants))
+	err := forEachParallel(len(variants), config.Workers, func(i int) error {
+		v := variants[i]
+		hash := v.hash()
+		published := fingerprintedPath(v.Image.variantPath(v.Width), hash)
+		publishedPaths[i] = published
+		if cache.upToDate(config.OutputDir, published, hash) {
+			return nil
+		}
+		cached, err := v.resize(cacheDir)
+		if err != nil {
+			return err
+		}
+		return out.CopyFile(published, cached, hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, v := range variants {
+		manifest[v.Image.variantPath(v.Width)] = publishedPaths[i]
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !used[entry.Name()] {
+			os.Remove(filepath.Join(cacheDir, entry.Name()))
+		}
+	}
+	return nil
+}
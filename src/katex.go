@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+// katexResult is the HTML KaTeX rendered an expression to, or the error it
+// reported.
+type katexResult struct {
+	HTML string
+	Err  error
+}
+
+// katexRenderer renders LaTeX to HTML and MathML by running katex.js in an
+// embedded JavaScript engine, so that math needs no script in the browser.
+// The engine is only started for the first expression to render, and
+// results are kept by source: an expression is rendered once per process
+// and version of katex.js.
+// They are kept in memory only; across builds, the build cache keeps the
+// HTML of articles whose sources and katex.js are unchanged, whose math is
+// then not rendered again. It is safe for concurrent use, expressions being
+// rendered one at a time.
+type katexRenderer struct {
+	script string // path of katex.js
+	source []byte // content of katex.js
+	hash   string // hash of source
+
+	mu             sync.Mutex
+	vm             *goja.Runtime
+	katex          *goja.Object
+	renderToString goja.Callable
+	results        map[string]katexResult // keyed by display mode and source
+}
+
+var (
+	katexRenderersMu sync.Mutex
+	katexRenderers   = make(map[string]*katexRenderer) // keyed by script path
+)
+
+// katexFor returns the renderer running the KaTeX of srcDir, shared by
+// every article. The renderer is replaced when katex.js changes, as it may
+// while serving.
+func katexFor(srcDir string) (*katexRenderer, error) {
+	script := filepath.Join(srcDir, "libs", "katex", "katex.js")
+	source, err := os.ReadFile(script)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashBytes(source)
+	katexRenderersMu.Lock()
+	defer katexRenderersMu.Unlock()
+	if r, ok := katexRenderers[script]; ok && r.hash == hash {
+		return r, nil
+	}
+	r := &katexRenderer{script: script, source: source, hash: hash, results: make(map[string]katexResult)}
+	katexRenderers[script] = r
+	return r, nil
+}
+
+// start loads katex.js into a new JavaScript runtime.
+func (r *katexRenderer) start() error {
+	vm := goja.New()
+	if _, err := vm.RunScript(r.script, string(r.source)); err != nil {
+		return fmt.Errorf("failed to load %s: %v", r.script, err)
+	}
+	katex := vm.Get("katex")
+	if katex == nil || goja.IsUndefined(katex) {
+		return fmt.Errorf("%s does not define katex", r.script)
+	}
+	renderToString, ok := goja.AssertFunction(katex.ToObject(vm).Get("renderToString"))
+	if !ok {
+		return fmt.Errorf("%s does not define katex.renderToString", r.script)
+	}
+	r.vm, r.katex, r.renderToString = vm, katex.ToObject(vm), renderToString
+	return nil
+}
+
+// render returns the HTML of the expression latex, in display mode or
+// inline. Invalid LaTeX is reported with the message of KaTeX.
+func (r *katexRenderer) render(latex string, display bool) (string, error) {
+	key := fmt.Sprintf("%t:%s", display, latex)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if result, ok := r.results[key]; ok {
+		return result.HTML, result.Err
+	}
+
+	if r.vm == nil {
+		if err := r.start(); err != nil {
+			// Not cached: every article reports why its math cannot be
+			// rendered.
+			return "", err
+		}
+	}
+	options := map[string]any{"displayMode": display, "throwOnError": true}
+	value, err := r.renderToString(r.katex, r.vm.ToValue(latex), r.vm.ToValue(options))
+	var result katexResult
+	var exception *goja.Exception
+	switch {
+	case errors.As(err, &exception):
+		message := exception.Value().String()
+		if object, ok := exception.Value().(*goja.Object); ok {
+			if m := object.Get("message"); m != nil && !goja.IsUndefined(m) {
+				message = m.String()
+			}
+		}
+		result.Err = errors.New(message)
+	case err != nil:
+		result.Err = err
+	default:
+		result.HTML = value.String()
+	}
+	r.results[key] = result
+	return result.HTML, result.Err
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// linkAttributes lists, per element, the attributes holding a URL that the
+// link checker follows.
+var linkAttributes = map[string][]string{
+	"a":      {"href"},
+	"link":   {"href"},
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+}
+
+// htmlReference is a URL found in a generated page.
+type htmlReference struct {
+	Element string
+	Value   string
+	Offset  int // byte offset of the element in the page
+}
+
+// htmlDocument is a generated page as seen by the link checker: the
+// fragment identifiers it defines and the URLs it references.
+type htmlDocument struct {
+	Rel     string // path relative to the output directory
+	Content []byte
+	IDs     map[string]bool
+	Refs    []htmlReference
+}
+
+// readHTMLDocument parses the page at rel in outputDir.
+func readHTMLDocument(outputDir, rel string) (*htmlDocument, error) {
+	content, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return nil, err
+	}
+	doc := &htmlDocument{Rel: rel, Content: content, IDs: make(map[string]bool)}
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	offset := 0
+	for {
+		tt := z.Next()
+		start := offset
+		offset += len(z.Raw())
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return doc, nil
+			}
+			return nil, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			for _, attr := range token.Attr {
+				switch {
+				case attr.Key == "id", attr.Key == "name" && token.Data == "a":
+					doc.IDs[attr.Val] = true
+				case attr.Key == "srcset":
+					for _, candidate := range strings.Split(attr.Val, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							doc.Refs = append(doc.Refs, htmlReference{Element: token.Data, Value: fields[0], Offset: start})
+						}
+					}
+				default:
+					for _, key := range linkAttributes[token.Data] {
+						if attr.Key == key {
+							doc.Refs = append(doc.Refs, htmlReference{Element: token.Data, Value: attr.Val, Offset: start})
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// resolveOutputFile returns the file, relative to outputDir, served for
+// the root-relative URL path p the way Cloudflare Pages does: /slug may be
+// slug.html or slug/index.html. It returns "" when no file matches.
+func resolveOutputFile(outputDir, p string) string {
+	rel := strings.TrimPrefix(p, "/")
+	candidates := []string{rel + "index.html"}
+	if rel != "" && !strings.HasSuffix(rel, "/") {
+		candidates = []string{rel, rel + ".html", rel + "/index.html"}
+	}
+	for _, candidate := range candidates {
+		info, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(candidate)))
+		if err == nil && info.Mode().IsRegular() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// linkChecker validates the references of every page generated in
+// outputDir. Problems are reported against the source of the reference
+// when it comes from article markdown, and against the page in reportDir
+// otherwise.
+type linkChecker struct {
+	outputDir string
+	reportDir string
+	docs      map[string]*htmlDocument
+	sources   map[string]string // page to the markdown file it was rendered from
+	bundles   map[string]string // page to the bundle of its article
+	logical   map[string]string // published asset path to logical path
+}
+
+// checkLinks reports, in diags, the broken internal links, fragment
+// identifiers, images and asset references of the site generated in
+// outputDir. External URLs are not checked.
+func checkLinks(site *Site, outputDir, reportDir string, diags *Diagnostics) error {
+	c := &linkChecker{
+		outputDir: outputDir,
+		reportDir: reportDir,
+		docs:      make(map[string]*htmlDocument),
+		sources:   make(map[string]string),
+		bundles:   make(map[string]string),
+		logical:   make(map[string]string),
+	}
+	config := site.Config
+	for logical, published := range site.Assets {
+		c.logical[published] = logical
+	}
+	for _, a := range site.Articles {
+		page := config.pageFilename(a.HTMLFilename)
+		c.sources[page] = filepath.Join(config.ArticleDir, a.Manifest.MarkdownFile)
+		c.bundles[page] = a.Bundle
+	}
+
+	err := filepath.WalkDir(outputDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != outputDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || filepath.Ext(p) != ".html" {
+			return nil
+		}
+		rel, err := filepath.Rel(outputDir, p)
+		if err != nil {
+			return err
+		}
+		doc, err := readHTMLDocument(outputDir, filepath.ToSlash(rel))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", rel, err)
+		}
+		c.docs[doc.Rel] = doc
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range c.docs {
+		for _, ref := range doc.Refs {
+			if problem := c.check(doc, ref.Value); problem != "" {
+				diags.Errorf(c.position(doc, ref), "broken %s reference %q: %s", ref.Element, ref.Value, problem)
+			}
+		}
+	}
+	return nil
+}
+
+// check returns what is wrong with the reference value found in doc, or ""
+// when it resolves.
+func (c *linkChecker) check(doc *htmlDocument, value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Sprintf("malformed URL: %v", err)
+	}
+	if u.Scheme != "" || u.Host != "" {
+		return ""
+	}
+
+	target := doc
+	if u.Path != "" {
+		base := &url.URL{Path: "/" + doc.Rel}
+		resolved := base.ResolveReference(u).Path
+		rel := resolveOutputFile(c.outputDir, resolved)
+		if rel == "" {
+			return fmt.Sprintf("%s does not exist", resolved)
+		}
+		target = c.docs[rel]
+	}
+
+	if u.Fragment == "" || u.Fragment == "top" || target == nil {
+		return ""
+	}
+	if !target.IDs[u.Fragment] {
+		return fmt.Sprintf("%s has no element with id %q", target.Rel, u.Fragment)
+	}
+	return ""
+}
+
+// sourceCandidates returns the ways the reference value may be written in
+// markdown, before asset fingerprinting and the rewriting of relative
+// references and page URLs. The references of a bundle are written relative
+// to its directory.
+func (c *linkChecker) sourceCandidates(value, bundle string) []string {
+	candidates := []string{value}
+	p, suffix := value, ""
+	if i := strings.IndexAny(value, "#?"); i >= 0 {
+		p, suffix = value[:i], value[i:]
+	}
+	if !strings.HasPrefix(p, "/") && bundle == "" {
+		return candidates
+	}
+	rel := strings.TrimPrefix(p, "/")
+	paths := []string{rel}
+	if logical, ok := c.logical[rel]; ok {
+		paths = append(paths, logical)
+	}
+	if page, ok := strings.CutSuffix(rel, "/"); ok && page != "" {
+		paths = append(paths, page+".html")
+	}
+	for _, p := range paths {
+		candidates = append(candidates, p+suffix)
+		if bundle == "" {
+			continue
+		}
+		if inBundle, ok := strings.CutPrefix(p, bundle+"/"); ok {
+			candidates = append(candidates, inBundle+suffix)
+		} else {
+			candidates = append(candidates, "../"+p+suffix)
+		}
+	}
+	return candidates
+}
+
+// position locates ref: in the markdown of the article when it is written
+// there, in the generated page otherwise.
+func (c *linkChecker) position(doc *htmlDocument, ref htmlReference) Position {
+	if source, ok := c.sources[doc.Rel]; ok {
+		if markdown, err := os.ReadFile(source); err == nil {
+			for _, candidate := range c.sourceCandidates(ref.Value, c.bundles[doc.Rel]) {
+				for _, prefix := range []string{"(", "(./", "<", "\"", "\"./", "'"} {
+					if i := bytes.Index(markdown, []byte(prefix+candidate)); i >= 0 {
+						return offsetPosition(source, markdown, i+len(prefix))
+					}
+				}
+			}
+		}
+	}
+	return offsetPosition(filepath.Join(c.reportDir, filepath.FromSlash(doc.Rel)), doc.Content, ref.Offset)
+}
+
+// checkGeneratedLinks renders the site from parsed articles into
+// config.OutputDir, a temporary directory, and checks its links. Problems
+// are reported against reportDir, where build would write the same pages.
+func checkGeneratedLinks(config Config, reportDir string, articles []Article, diags *Diagnostics) error {
+	// Unminified pages give meaningful line numbers.
+	config.MinifyHTML = false
+	cache, err := loadBuildCache(config.OutputDir, true)
+	if err != nil {
+		return err
+	}
+	site, err := buildSite(config, cache, articles)
+	if err != nil {
+		return err
+	}
+	return checkLinks(site, config.OutputDir, reportDir, diags)
+}
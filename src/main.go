@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/a-h/templ"
 )
@@ -25,12 +28,42 @@ const (
 	AssetScopeArticle AssetScope = "article" // loaded from articleDir
 )
 
-// Asset describes a CSS or JS file that will be minified and inlined
-// into the <head> of a page.
+// AssetDelivery determines how an asset reaches the page.
+type AssetDelivery string
+
+const (
+	AssetAuto   AssetDelivery = "auto"   // inlined up to SiteConfig.AssetInlineLimit bytes, linked above
+	AssetInline AssetDelivery = "inline" // embedded in a <style> or <script> tag
+	AssetLinked AssetDelivery = "linked" // referenced from its published, fingerprinted file
+)
+
+// Asset describes a CSS or JS file that will be minified and either inlined
+// into the <head> of a page or linked from it. The zero Delivery means
+// AssetAuto.
 type Asset struct {
 	Filename string
 	Kind     AssetKind
 	Scope    AssetScope
+	Delivery AssetDelivery
+}
+
+// With returns a copy of the asset delivered in mode d.
+func (a Asset) With(d AssetDelivery) Asset {
+	a.Delivery = d
+	return a
+}
+
+// publishedPath returns the logical path the asset is published at by
+// publishAssets: article styles and scripts are flattened next to the
+// global ones, unless they belong to a bundle.
+func (a Asset) publishedPath() string {
+	if a.Scope == AssetScopeArticle && articleBundle(a.Filename) != "" {
+		return filepath.ToSlash(a.Filename)
+	}
+	if a.Kind == AssetJS {
+		return "scripts/" + a.Filename
+	}
+	return "css/" + a.Filename
 }
 
 // Page describes a single HTML page to be generated: its output filename,
@@ -38,17 +71,68 @@ type Asset struct {
 //
 // Render receives the resolved inline style and script tags so that each
 // page template can embed them directly in the <head>.
+//
+// Inputs holds the data captured by Render. It is hashed together with the
+// page's assets to decide whether the page must be rendered again.
+//
+// RootRelative makes every relative reference of the page root-relative,
+// for pages served at any URL, such as 404.html. It is implied by pretty
+// URLs.
 type Page struct {
-	Filename string
-	Assets   []Asset
-	Render   func(styleTags, scriptTags []string) templ.Component
+	Filename     string
+	Assets       []Asset
+	Inputs       any
+	Render       func(styleTags, scriptTags []string) templ.Component
+	RootRelative bool
+}
+
+// assetSourcePath returns the path of the file an asset is loaded from.
+func assetSourcePath(asset Asset, srcDir, articleDir string) string {
+	if asset.Scope == AssetScopeArticle {
+		return filepath.Join(articleDir, asset.Filename)
+	}
+	if asset.Kind == AssetJS {
+		return filepath.Join(srcDir, "scripts", asset.Filename)
+	}
+	return filepath.Join(srcDir, "css", asset.Filename)
 }
 
-// inlineAssets minifies all assets declared on a page and returns
-// the resulting <style> and <script> tag strings for inline embedding.
-func inlineAssets(page Page, srcDir, articleDir string) ([]string, []string, error) {
+// pageHash hashes everything a page is rendered from: its captured data,
+// the site configuration, the published asset paths it may link to, the
+// content of every declared asset, and the options transformers depend on.
+func pageHash(page Page, s *Site) (string, error) {
+	config := s.Config
+	inputs, err := json.Marshal(page.Inputs)
+	if err != nil {
+		return "", err
+	}
+	site, err := json.Marshal(config.Site)
+	if err != nil {
+		return "", err
+	}
+	assets, err := json.Marshal(s.Assets)
+	if err != nil {
+		return "", err
+	}
+	paths := make([]string, 0, len(page.Assets))
+	for _, asset := range page.Assets {
+		paths = append(paths, assetSourcePath(asset, config.SrcDir, config.ArticleDir))
+	}
+	assetsHash, err := hashFiles(paths...)
+	if err != nil {
+		return "", err
+	}
+	options := []byte(fmt.Sprintf("minifyHTML=%t prettyURLs=%t", config.MinifyHTML, config.PrettyURLs))
+	return hashBytes(inputs, site, assets, []byte(assetsHash), options), nil
+}
+
+// assetTags minifies all assets declared on a page and returns the
+// resulting <style> and <script> tags for inline embedding, or <link> and
+// <script src> tags pointing at the published file for linked assets.
+func assetTags(page Page, site *Site) ([]string, []string, error) {
 	var styleTags []string
 	var scriptTags []string
+	srcDir, articleDir := site.Config.SrcDir, site.Config.ArticleDir
 
 	for _, asset := range page.Assets {
 		var content string
@@ -75,10 +159,34 @@ func inlineAssets(page Page, srcDir, articleDir string) ([]string, []string, err
 			return nil, nil, fmt.Errorf("failed to inline asset %s: %w", asset.Filename, err)
 		}
 
-		switch asset.Kind {
-		case AssetCSS:
+		// Global stylesheets are pruned per page by cssPruner, which decides
+		// on their pruned size whether they are inlined.
+		prunable := asset.Kind == AssetCSS && asset.Scope == AssetScopeGlobal
+		auto := asset.Delivery != AssetInline && asset.Delivery != AssetLinked
+		linked := asset.Delivery == AssetLinked ||
+			(auto && !prunable && len(content) > site.Config.Site.AssetInlineLimit)
+		href, published := site.Assets[asset.publishedPath()]
+		if (linked || (auto && prunable)) && !published {
+			return nil, nil, fmt.Errorf("asset %s may be linked but was not published", asset.Filename)
+		}
+		if linked {
+			switch asset.Kind {
+			case AssetCSS:
+				styleTags = append(styleTags, fmt.Sprintf("<link rel=\"stylesheet\" href=\"%s\"/>", href))
+			case AssetJS:
+				scriptTags = append(scriptTags, fmt.Sprintf("<script src=\"%s\"></script>", href))
+			}
+			continue
+		}
+
+		switch {
+		case prunable && auto:
+			styleTags = append(styleTags, prunableStyle(content, href))
+		case prunable:
+			styleTags = append(styleTags, prunableStyle(content, ""))
+		case asset.Kind == AssetCSS:
 			styleTags = append(styleTags, fmt.Sprintf("<style type='text/css'>%s</style>", content))
-		case AssetJS:
+		case asset.Kind == AssetJS:
 			scriptTags = append(scriptTags, fmt.Sprintf("<script>%s</script>", content))
 		}
 	}
@@ -107,7 +215,8 @@ func articleJS(filename string) Asset {
 }
 
 // articlePage builds a Page for a single blog article.
-func articlePage(a Article) Page {
+func articlePage(config Config, a Article) Page {
+	site := config.Site
 	assets := []Asset{
 		globalCSS("article.css"),
 		globalCSS("syntax-highlighting.css"),
@@ -123,140 +232,214 @@ func articlePage(a Article) Page {
 	}
 
 	return Page{
-		Filename: a.HTMLFilename,
+		Filename: config.pageFilename(a.HTMLFilename),
 		Assets:   assets,
+		Inputs:   a,
 		Render: func(styleTags, scriptTags []string) templ.Component {
-			return article(a.Manifest.Title, a.Manifest.Description, a.StringifiedHTML, a.FormattedDate, scriptTags, styleTags, a.TOC)
+			return article(site, a.Manifest.Title, a.Manifest.Description, a.StringifiedHTML, a.FormattedDate, scriptTags, styleTags, a.TOC)
 		},
 	}
 }
 
-// publishGlobalCSS minifies each CSS file listed in config.CSSFiles and
-// writes the result to the output CSS directory.
-func publishGlobalCSS(config Config) error {
-	for _, filename := range config.CSSFiles {
-		minifiedCSS, err := MinifyCSS(config.SrcDir + "/css/" + filename)
-		if err != nil {
-			return fmt.Errorf("failed to minify %s: %v", filename, err)
-		}
+// staticPages generates the four static pages: home, articles, resume and
+// resume-printable.
+type staticPages struct{}
 
-		newFilename := config.OutputDir + "/css/" + filename
-		if err := os.WriteFile(newFilename, []byte(minifiedCSS), config.FileMode); err != nil {
-			return fmt.Errorf("failed to write %s: %v", newFilename, err)
-		}
+func (staticPages) Name() string { return "static pages" }
+
+func (staticPages) PostProcess(site *Site, out *outputWriter) error { return nil }
+
+func (staticPages) Pages(s *Site) []Page {
+	config := s.Config
+	site := config.Site
+	allArticles := s.Articles
+	experiences := s.Experiences
+
+	var mostRecent []Article
+	if len(allArticles) > 0 {
+		mostRecent = allArticles[:1]
 	}
-	return nil
-}
 
-// buildPages constructs all Page descriptors for the site: the four static
-// pages (home, articles, resume, resume-printable) plus one page per article.
-func buildPages(allArticles []Article, experiences ExperiencesData) []Page {
-	pages := []Page{
+	return []Page{
 		{
-			Filename: "index.html",
+			Filename: config.pageFilename("index.html"),
 			Assets: []Asset{
 				globalCSS("home.css"),
 				globalCSS("articles.css"),
 			},
+			Inputs: mostRecent,
 			Render: func(styleTags, scriptTags []string) templ.Component {
-				var mostRecent []Article
-				if len(allArticles) > 0 {
-					mostRecent = allArticles[:1]
-				}
-				return home(mostRecent, styleTags)
+				return home(site, mostRecent, styleTags)
 			},
 		},
 		{
-			Filename: "articles.html",
+			Filename: config.pageFilename("articles.html"),
 			Assets: []Asset{
 				globalCSS("articles.css"),
 			},
+			Inputs: allArticles,
 			Render: func(styleTags, scriptTags []string) templ.Component {
-				return articles(allArticles, styleTags)
+				return articles(site, allArticles, styleTags)
 			},
 		},
 		{
-			Filename: "resume.html",
+			Filename: config.pageFilename("resume.html"),
 			Assets: []Asset{
 				globalCSS("resume.css"),
 			},
+			Inputs: experiences,
 			Render: func(styleTags, scriptTags []string) templ.Component {
-				return resumePage(experiences.WorkExperiences, experiences.SchoolExperiences, styleTags)
+				return resumePage(site, experiences.WorkExperiences, experiences.SchoolExperiences, styleTags)
 			},
 		},
 		{
-			Filename: "resume-printable.html",
+			// Inlined so that a saved copy of the printable resume is
+			// self-contained.
+			Filename: config.pageFilename("resume-printable.html"),
 			Assets: []Asset{
-				globalCSS("resume.css"),
+				globalCSS("resume.css").With(AssetInline),
 			},
+			Inputs: experiences,
 			Render: func(styleTags, scriptTags []string) templ.Component {
-				return resumePrintReady(experiences.WorkExperiences, experiences.SchoolExperiences, styleTags)
+				return resumePrintReady(site, experiences.WorkExperiences, experiences.SchoolExperiences, styleTags)
 			},
 		},
 	}
+}
 
-	for _, a := range allArticles {
-		pages = append(pages, articlePage(a))
-	}
+// articlePages generates one page per article.
+type articlePages struct{}
+
+func (articlePages) Name() string { return "article pages" }
+
+func (articlePages) PostProcess(site *Site, out *outputWriter) error { return nil }
 
+func (articlePages) Pages(site *Site) []Page {
+	pages := make([]Page, 0, len(site.Articles))
+	for _, a := range site.Articles {
+		pages = append(pages, articlePage(site.Config, a))
+	}
 	return pages
 }
 
-// generateAllPages renders each Page to an HTML file in the output directory.
-func generateAllPages(config Config, allArticles []Article, experiences ExperiencesData) error {
-	pages := buildPages(allArticles, experiences)
+// generateAllPages renders the pages of every generator to HTML files
+// staged in out.
+func generateAllPages(site *Site, out *outputWriter, cache *buildCache) error {
+	if err := checkPageFilenames(site); err != nil {
+		return err
+	}
+	return generatePages(site, out, site.Pages(), cache)
+}
 
-	for _, page := range pages {
-		filename := config.OutputDir + "/" + page.Filename
-		file, err := os.OpenFile(filename, config.FileFlags, config.FileMode)
+// generatePages renders the given pages to HTML files staged in out,
+// skipping pages whose inputs did not change since the previous build.
+// Rendered HTML goes through every registered Transformer. Pages are
+// rendered concurrently by up to Config.Workers goroutines.
+func generatePages(site *Site, out *outputWriter, pages []Page, cache *buildCache) error {
+	config := site.Config
+	err := forEachParallel(len(pages), config.Workers, func(i int) error {
+		page := pages[i]
+		hash, err := pageHash(page, site)
 		if err != nil {
-			return fmt.Errorf("could not open file %s: %v", filename, err)
+			return fmt.Errorf("failed to hash inputs of page %s: %v", page.Filename, err)
+		}
+		if cache.upToDate(config.OutputDir, page.Filename, hash) {
+			return nil
 		}
 
-		styleTags, scriptTags, err := inlineAssets(page, config.SrcDir, config.ArticleDir)
+		styleTags, scriptTags, err := assetTags(page, site)
 		if err != nil {
-			return fmt.Errorf("failed to inline assets for page %s: %v", page.Filename, err)
+			return fmt.Errorf("failed to resolve assets for page %s: %v", page.Filename, err)
 		}
 
-		page.Render(styleTags, scriptTags).Render(context.Background(), file)
-	}
+		var buf bytes.Buffer
+		if err := page.Render(styleTags, scriptTags).Render(context.Background(), &buf); err != nil {
+			return fmt.Errorf("failed to render page %s: %v", page.Filename, err)
+		}
+		rendered, err := transformHTML(site, page, buf.String())
+		if err != nil {
+			return fmt.Errorf("failed to transform page %s: %v", page.Filename, err)
+		}
 
+		return out.WriteFile(page.Filename, []byte(rendered), hash)
+	})
+	if err != nil {
+		return err
+	}
+	reportTransformers(site.Config.Quiet)
 	return nil
 }
 
-// postProcessing runs tasks that depend on all pages already being written:
-// sitemap generation.
-func postProcessing(config Config, allArticles []Article) error {
-	if err := generateSitemap(config.OutputDir, config.BaseURL, allArticles); err != nil {
-		log.Printf("Warning: Failed to generate sitemap: %v", err)
+// build runs the whole pipeline once and returns the resulting Site so
+// that callers like serve can later rebuild parts of it. Every output is
+// staged and only moved into the output directory once the whole build
+// succeeded. Unchanged articles and pages are skipped using cache, which is
+// saved after the outputs are committed.
+func build(config Config, cache *buildCache) (*Site, error) {
+	diags := &Diagnostics{}
+	allArticles, err := parseArticles(config, cache, diags)
+	if err != nil {
+		return nil, fmt.Errorf("error loading articles: %v", err)
 	}
-
-	return nil
+	diags.Print(os.Stderr)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error loading articles: %s", diags.Summary())
+	}
+	return buildSite(config, cache, allArticles)
 }
 
-func main() {
-	config := LoadConfig()
-	InitMinifier()
+// buildSite is build once articles are parsed: it publishes assets, renders
+// every page, runs post-processing and commits the outputs.
+func buildSite(config Config, cache *buildCache, allArticles []Article) (*Site, error) {
+	out, err := newOutputWriter(config, cache)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Discard()
 
-	if err := publishGlobalCSS(config); err != nil {
-		log.Fatalf("Error publishing global CSS: %v", err)
+	assets, err := publishAssets(config, out, cache, allArticles)
+	if err != nil {
+		return nil, fmt.Errorf("error publishing assets: %v", err)
 	}
 
 	experiences, err := loadExperiencesFromJSON(config.SrcDir + "/experiences.json")
 	if err != nil {
-		log.Fatalf("Error loading experiences: %v", err)
+		return nil, fmt.Errorf("error loading experiences: %v", err)
 	}
 
-	allArticles, err := parseArticles(config.ArticleDir, config.Env)
-	if err != nil {
-		log.Fatalf("Error loading articles: %v", err)
+	site := &Site{
+		Config:      config,
+		Articles:    allArticles,
+		Experiences: *experiences,
+		Assets:      assets,
 	}
 
-	if err := generateAllPages(config, allArticles, *experiences); err != nil {
-		log.Fatalf("Error generating pages: %v", err)
+	if err := generateAllPages(site, out, cache); err != nil {
+		return nil, fmt.Errorf("error generating pages: %v", err)
 	}
 
-	if err := postProcessing(config, allArticles); err != nil {
-		log.Fatalf("Error in post-processing: %v", err)
+	if err := postProcessing(site, out); err != nil {
+		return nil, fmt.Errorf("error in post-processing: %v", err)
+	}
+
+	for _, stale := range cache.prune() {
+		out.Remove(stale)
+	}
+	if err := out.Commit(); err != nil {
+		return nil, fmt.Errorf("error writing output: %v", err)
+	}
+	if err := cache.save(); err != nil {
+		return nil, fmt.Errorf("error saving build cache: %v", err)
+	}
+
+	return site, nil
+}
+
+func main() {
+	if err := runCLI(os.Args[1:]); err != nil {
+		if err != errUsage {
+			log.Print(err)
+		}
+		os.Exit(1)
 	}
 }
@@ -22,9 +22,6 @@ import (
 )
 
 var (
-	multiLineDisplayRegex  = regexp.MustCompile(`(?s)\\\[\s*\n(.*?)\n\s*\\\]`)
-	singleLineDisplayRegex = regexp.MustCompile(`\\\[([^\n]*?)\\\]`)
-	dollarInlineMathRegex  = regexp.MustCompile(`\$([^$\n]+)\$`)
 	dynamicColorImageRegex = regexp.MustCompile(`!\[([^\]]*)\]\(([^)]+)\)\{\.dynamic-colors\}`)
 )
 
@@ -38,12 +35,28 @@ var (
 //   - language:filename:diff
 type filenameTitleTransformer struct{}
 
+// markdownChecker is a Goldmark AST transformer that reports problems in
+// the markdown source: fenced code blocks whose language is not known to
+// Chroma, and whether the document has a level-1 heading.
+type markdownChecker struct {
+	filename string
+	diags    *Diagnostics
+	hasH1    bool
+}
+
 // tocExtractor is a Goldmark AST transformer that walks the document
 // and collects heading nodes into a table of contents.
 type tocExtractor struct {
 	TOC []TOCEntry
 }
 
+// linkCollector is a Goldmark AST transformer that collects the external
+// links and images of the document, in document order.
+type linkCollector struct {
+	filename string
+	links    []ExternalLink
+}
+
 func (t *filenameTitleTransformer) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
 	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
@@ -78,6 +91,32 @@ func (t *filenameTitleTransformer) Transform(node *ast.Document, reader text.Rea
 	})
 }
 
+func (c *markdownChecker) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Heading:
+			if n.Level == 1 {
+				c.hasH1 = true
+			}
+		case *ast.FencedCodeBlock:
+			if n.Info == nil {
+				return ast.WalkContinue, nil
+			}
+			lang := strings.Split(string(n.Language(source)), ":")[0]
+			if lang != "" && lang != "directory-structure" && lexers.Get(lang) == nil {
+				pos := offsetPosition(c.filename, source, n.Info.Segment.Start)
+				c.diags.Warnf(pos, "unknown code block language %q, highlighting as plain text", lang)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
 func (toc *tocExtractor) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
 	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
@@ -114,6 +153,83 @@ func (toc *tocExtractor) Transform(node *ast.Document, reader text.Reader, pc pa
 	})
 }
 
+func (c *linkCollector) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	offset := 0
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		var destination []byte
+		switch n := n.(type) {
+		case *ast.Link:
+			destination = n.Destination
+		case *ast.Image:
+			destination = n.Destination
+		case *ast.AutoLink:
+			destination = n.URL(source)
+		default:
+			return ast.WalkContinue, nil
+		}
+		if !isExternalURL(string(destination)) {
+			return ast.WalkContinue, nil
+		}
+
+		// Links carry no position: the destination is looked up in the
+		// source, after the previous link since the walk is in document
+		// order.
+		link := ExternalLink{URL: string(destination), Position: Position{File: c.filename}}
+		if i := bytes.Index(source[offset:], destination); i >= 0 {
+			offset += i
+			link.Position = offsetPosition(c.filename, source, offset)
+			offset += len(destination)
+		}
+		c.links = append(c.links, link)
+		return ast.WalkContinue, nil
+	})
+}
+
+// imageRenderer is a custom Goldmark renderer for images. Local images get
+// their intrinsic size and resized variants (see images.go), and every
+// image is loaded lazily.
+type imageRenderer struct {
+	images *imageResolver
+}
+
+func (r *imageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindImage, r.renderImage)
+}
+
+func (r *imageRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+
+	var alt bytes.Buffer
+	ast.Walk(n, func(child ast.Node, childEntering bool) (ast.WalkStatus, error) {
+		if !childEntering {
+			return ast.WalkContinue, nil
+		}
+		switch child := child.(type) {
+		case *ast.Text:
+			alt.Write(child.Segment.Value(source))
+		case *ast.String:
+			alt.Write(child.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	fmt.Fprintf(w, `<img src="%s" alt="%s"`, util.EscapeHTML(util.URLEscape(n.Destination, true)), util.EscapeHTML(alt.Bytes()))
+	if n.Title != nil {
+		fmt.Fprintf(w, ` title="%s"`, util.EscapeHTML(n.Title))
+	}
+	w.WriteString(r.images.attributes(string(n.Destination)))
+	w.WriteString(` loading="lazy" decoding="async" />`)
+	return ast.WalkSkipChildren, nil
+}
+
 // codeBlockRenderer is a custom Goldmark renderer for fenced code blocks.
 // It handles syntax highlighting via Chroma, diff annotations, filename
 // headers, and directory-structure rendering.
@@ -192,9 +308,10 @@ func (r *codeBlockRenderer) renderCodeBlock(w util.BufWriter, source []byte, nod
 		if hasFilename {
 			filename := string(filenameAttr.([]byte))
 			fileIcon := getFileIcon(filename)
+			escaped := util.EscapeHTML([]byte(filename))
 			w.WriteString("<div class=\"code-filename\">")
-			w.WriteString(fmt.Sprintf("<span><i class=\"%s\"></i> %s</span>", fileIcon, filename))
-			w.WriteString(fmt.Sprintf("<span class=\"copy-filename\" title=\"Copy filename\" onclick=\"navigator.clipboard.writeText('%s')\"><i class=\"fas fa-copy\"></i></span>", filename))
+			w.WriteString(fmt.Sprintf("<span><i class=\"%s\"></i> %s</span>", fileIcon, escaped))
+			w.WriteString(fmt.Sprintf("<span class=\"copy-filename\" title=\"Copy filename\" data-filename=\"%s\"><i class=\"fas fa-copy\"></i></span>", escaped))
 			w.WriteString("</div>")
 		}
 
@@ -267,54 +384,21 @@ func (r *codeBlockRenderer) renderCodeBlock(w util.BufWriter, source []byte, nod
 
 // preprocessDynamicColorImages rewrites markdown image syntax with a
 // {.dynamic-colors} attribute suffix into raw HTML img tags, adding the
-// required class and crossorigin attributes for the color-shifting mechanism.
+// required class and crossorigin attributes for the color-shifting mechanism,
+// the palette of the image computed at build time (see palette.go), and the
+// size and variants of local images. They are not loaded lazily: the color
+// shift reads their pixels once the page loads.
 //
 // Example input:  ![alt text](./images/foo.png){.dynamic-colors}
-// Example output: <img src="./images/foo.png" alt="alt text" class="dynamic-colors" crossorigin="anonymous">
-func preprocessDynamicColorImages(input string) string {
+// Example output: <img src="./images/foo.png" alt="alt text" class="dynamic-colors" crossorigin="anonymous" data-dominant-color="#1f6b3a" data-palette="#1f6b3a #c0392b ..." width="500" height="500" srcset="..." sizes="..." decoding="async">
+func preprocessDynamicColorImages(input string, images *imageResolver) string {
 	return dynamicColorImageRegex.ReplaceAllStringFunc(input, func(match string) string {
 		parts := dynamicColorImageRegex.FindStringSubmatch(match)
 		alt, src := parts[1], parts[2]
-		return fmt.Sprintf(`<img src="%s" alt="%s" class="dynamic-colors" crossorigin="anonymous">`, src, alt)
+		return fmt.Sprintf(`<img src="%s" alt="%s" class="dynamic-colors" crossorigin="anonymous"%s%s decoding="async">`, src, alt, images.paletteAttributes(src), images.attributes(src))
 	})
 }
 
-// processLatexExpressions converts LaTeX math notation in markdown to
-// HTML elements for client-side KaTeX rendering. Fenced code blocks
-// are excluded from processing.
-func processLatexExpressions(input string) string {
-	codeBlockRegex := regexp.MustCompile("(?s)```.*?```")
-	var blocks []string
-	placeholder := "\x00CODEBLOCK%d\x00"
-
-	stripped := codeBlockRegex.ReplaceAllStringFunc(input, func(match string) string {
-		idx := len(blocks)
-		blocks = append(blocks, match)
-		return fmt.Sprintf(placeholder, idx)
-	})
-
-	processed := multiLineDisplayRegex.ReplaceAllStringFunc(stripped, func(match string) string {
-		content := multiLineDisplayRegex.FindStringSubmatch(match)[1]
-		return fmt.Sprintf("<div class=\"katex-display\" data-latex=\"%s\"></div>", content)
-	})
-
-	processed = singleLineDisplayRegex.ReplaceAllStringFunc(processed, func(match string) string {
-		content := singleLineDisplayRegex.FindStringSubmatch(match)[1]
-		return fmt.Sprintf("<div class=\"katex-display\" data-latex=\"%s\"></div>", content)
-	})
-
-	processed = dollarInlineMathRegex.ReplaceAllStringFunc(processed, func(match string) string {
-		content := dollarInlineMathRegex.FindStringSubmatch(match)[1]
-		return fmt.Sprintf("<span class=\"katex-inline\" data-latex=\"%s\"></span>", content)
-	})
-
-	for i, block := range blocks {
-		processed = strings.Replace(processed, fmt.Sprintf(placeholder, i), block, 1)
-	}
-
-	return processed
-}
-
 // injectBylineBeforeFirstH1 inserts an author byline div immediately
 // before the first <h1> tag in the HTML.
 func injectBylineBeforeFirstH1(rawHTML string, formattedDate string, author string, authorImage string) (string, error) {
@@ -331,19 +415,24 @@ func injectBylineBeforeFirstH1(rawHTML string, formattedDate string, author stri
 }
 
 // parseArticleMarkdown converts a markdown file to HTML using Goldmark with
-// custom renderers for code blocks and headings. Returns the processed HTML,
-// extracted table of contents, and any error.
-func parseArticleMarkdown(filename string, formattedDate string, author string, authorImage string) (string, []TOCEntry, error) {
+// custom renderers for code blocks, headings and images. Returns the
+// processed HTML, extracted table of contents, external links, and any
+// error. Warnings are recorded in diags, located through the source
+// segments of the Goldmark AST. Local images are read and recorded through
+// images. The relative references of the markdown of a bundle are rebased
+// onto it.
+func parseArticleMarkdown(filename string, bundle string, formattedDate string, author string, authorImage string, images *imageResolver, math *katexRenderer, diags *Diagnostics) (string, []TOCEntry, []ExternalLink, error) {
 	var buf bytes.Buffer
 	input, err := os.ReadFile(filename)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	tocExtractor := &tocExtractor{TOC: []TOCEntry{}}
+	checker := &markdownChecker{filename: filename, diags: diags}
+	links := &linkCollector{filename: filename}
 
-	processedInput := preprocessDynamicColorImages(string(input))
-	processedInput = processLatexExpressions(processedInput)
+	processedInput := preprocessDynamicColorImages(string(input), images)
 
 	htmlRenderer := renderer.NewRenderer(
 		renderer.WithNodeRenderers(
@@ -354,6 +443,7 @@ func parseArticleMarkdown(filename string, formattedDate string, author string,
 			), 100),
 			util.Prioritized(&codeBlockRenderer{}, 80),
 			util.Prioritized(&headingRenderer{}, 70),
+			util.Prioritized(&imageRenderer{images: images}, 60),
 		),
 	)
 
@@ -361,6 +451,7 @@ func parseArticleMarkdown(filename string, formattedDate string, author string,
 		goldmark.WithRenderer(htmlRenderer),
 		goldmark.WithExtensions(
 			extension.GFM,
+			&mathExtension{math: math, filename: filename, diags: diags},
 			extension.NewFootnote(
 				extension.WithFootnoteBacklinkTitle("Return to text"),
 				extension.WithFootnoteLinkTitle(""),
@@ -371,23 +462,36 @@ func parseArticleMarkdown(filename string, formattedDate string, author string,
 			parser.WithAutoHeadingID(),
 			parser.WithASTTransformers(
 				util.Prioritized(&filenameTitleTransformer{}, 100),
+				util.Prioritized(checker, 60),
 				util.Prioritized(tocExtractor, 50),
+				util.Prioritized(links, 40),
 			),
 		),
 	)
 
 	err = p.Convert([]byte(processedInput), &buf)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
+	}
+
+	if !checker.hasH1 {
+		return "", nil, nil, Diagnostic{
+			Position: Position{File: filename, Line: 1},
+			Severity: SeverityError,
+			Message:  "no level-1 heading: articles must start with a '# Title' line",
+		}
 	}
 
 	rawHTML := buf.String()
+	if bundle != "" {
+		rawHTML = rebaseBundleReferences(rawHTML, bundle)
+	}
 	processedHTML, err := injectBylineBeforeFirstH1(rawHTML, formattedDate, author, authorImage)
 	if err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
-	return processedHTML, tocExtractor.TOC, nil
+	return processedHTML, tocExtractor.TOC, links.links, nil
 }
 
 // hasFootnotes returns true if the HTML contains a footnotes section.
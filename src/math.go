@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Math is written in the markdown of articles with the delimiters of LaTeX
+// and Pandoc, and rendered by KaTeX at build time (see katex.go):
+//
+//	$x^2$        \(x^2\)        inline
+//	$$x^2$$      \[x^2\]        display
+//
+// Display math on lines of its own is a block:
+//
+//	\[
+//	V_{linear} = V_{encoded}^{\gamma}
+//	\]
+//
+// Math is only parsed where Goldmark parses inline content: never in code
+// spans, code blocks or HTML blocks, and an escaped \$ is a dollar sign.
+// Math in an HTML block is reported: a blank line after the opening tag
+// makes the math a paragraph of its own.
+// An inline $ must not be followed by a space, and the closing one must
+// not be preceded by a space nor followed by a digit, so that prices such
+// as $5 and $10 are left alone. Inline math ends on the line it starts on,
+// before any code span.
+//
+// Display math holding a \label is numbered, see equations.go.
+
+var (
+	kindMathInline = ast.NewNodeKind("MathInline")
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+// mathDelimiter is an opening delimiter of math and its closing one.
+type mathDelimiter struct {
+	open, close string
+	display     bool
+}
+
+// mathDelimiters are tried in order: $$ before $.
+var mathDelimiters = []mathDelimiter{
+	{open: "$$", close: "$$", display: true},
+	{open: "$", close: "$"},
+	{open: `\[`, close: `\]`, display: true},
+	{open: `\(`, close: `\)`},
+}
+
+// mathInline is an expression within a line of text. Display math written
+// inline, such as \[x^2\] in the middle of a paragraph, is a mathInline
+// rendered in display mode.
+type mathInline struct {
+	ast.BaseInline
+	equation
+	Display bool
+	Start   int          // offset of the opening delimiter in the source
+	Value   text.Segment // the LaTeX, between the delimiters
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Display": fmt.Sprint(n.Display),
+		"Value":   string(n.Value.Value(source)),
+	}, nil)
+}
+
+// mathBlock is display math on lines of its own, its lines holding the
+// LaTeX.
+type mathBlock struct {
+	ast.BaseBlock
+	equation
+	Start     int    // offset of the opening delimiter in the source
+	Delimiter string // closing delimiter
+	Closed    bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathInlineParser parses inline math. It is triggered by \ as well as $
+// so that it sees \( and \[ before the backslash escapes the bracket.
+type mathInlineParser struct{}
+
+func (p *mathInlineParser) Trigger() []byte {
+	return []byte{'$', '\\'}
+}
+
+func (p *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+	for _, d := range mathDelimiters {
+		if !bytes.HasPrefix(line, []byte(d.open)) {
+			continue
+		}
+		end := findMathClose(line, d)
+		if end < 0 {
+			return nil
+		}
+		node := &mathInline{
+			Display: d.display,
+			Start:   segment.Start,
+			Value:   text.NewSegment(segment.Start+len(d.open), segment.Start+end),
+		}
+		block.Advance(end + len(d.close))
+		return node
+	}
+	return nil
+}
+
+// findMathClose returns the offset in line of the delimiter closing the
+// math line opens with d, or -1.
+func findMathClose(line []byte, d mathDelimiter) int {
+	start := len(d.open)
+	if d.open == "$" && (start >= len(line) || util.IsSpace(line[start])) {
+		return -1
+	}
+	for i := start; i < len(line); i++ {
+		if line[i] == '\n' || line[i] == '`' {
+			// Math does not run into a code span.
+			return -1
+		}
+		if !bytes.HasPrefix(line[i:], []byte(d.close)) || i == start {
+			continue
+		}
+		if d.open == "$" {
+			// \$ is a dollar sign in LaTeX too, and $$ opens display math.
+			if line[i-1] == '\\' || util.IsSpace(line[i-1]) || (i+1 < len(line) && (util.IsNumeric(line[i+1]) || line[i+1] == '$')) {
+				continue
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+// mathBlockParser parses display math on lines of its own: a line opening
+// with $$ or \[, up to the line closing it. A single line holding the
+// whole expression is a block too, when nothing follows it.
+type mathBlockParser struct{}
+
+func (p *mathBlockParser) Trigger() []byte {
+	return []byte{'$', '\\'}
+}
+
+func (p *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+	for _, d := range mathDelimiters {
+		if !d.display || !bytes.HasPrefix(line[pos:], []byte(d.open)) {
+			continue
+		}
+		// offset converts an offset in line to one in the source.
+		offset := func(i int) int { return segment.Start - segment.Padding + i }
+		start := pos + len(d.open)
+		node := &mathBlock{Start: offset(pos), Delimiter: d.close}
+		if end := bytes.Index(line[start:], []byte(d.close)); end >= 0 {
+			end += start
+			if !util.IsBlank(line[end+len(d.close):]) {
+				// Text follows: this is inline math of a paragraph.
+				return nil, parser.NoChildren
+			}
+			node.Lines().Append(text.NewSegment(offset(start), offset(end)))
+			node.Closed = true
+			return node, parser.NoChildren
+		}
+		if !util.IsBlank(line[start:]) {
+			node.Lines().Append(text.NewSegment(offset(start), segment.Stop))
+		}
+		return node, parser.NoChildren
+	}
+	return nil, parser.NoChildren
+}
+
+func (p *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.Closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if end := bytes.Index(line, []byte(n.Delimiter)); end >= 0 && util.IsBlank(line[end+len(n.Delimiter):]) {
+		if !util.IsBlank(line[:end]) {
+			n.Lines().Append(text.NewSegment(segment.Start, segment.Start+end))
+		}
+		n.Closed = true
+		reader.Advance(segment.Len() - 1)
+		return parser.Close
+	}
+	n.Lines().Append(segment)
+	return parser.Continue | parser.NoChildren
+}
+
+func (p *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// htmlMathChecker is a Goldmark AST transformer warning about math written
+// in HTML blocks, which is left as text. Raw blocks such as <script> and
+// comments are not checked.
+type htmlMathChecker struct {
+	filename string
+	diags    *Diagnostics
+}
+
+func (t *htmlMathChecker) Transform(node *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		block, ok := n.(*ast.HTMLBlock)
+		if !entering || !ok || block.HTMLBlockType == ast.HTMLBlockType1 || block.HTMLBlockType == ast.HTMLBlockType2 {
+			return ast.WalkContinue, nil
+		}
+		lines := block.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			line := lines.At(i)
+			if offset := findMath(line.Value(source)); offset >= 0 {
+				t.diags.Warnf(offsetPosition(t.filename, source, line.Start+offset),
+					"math in an HTML block is not rendered: add a blank line before it")
+				break
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// findMath returns the offset in line of the first delimiter opening math,
+// or -1. Display math may close on a later line; inline math may not.
+func findMath(line []byte) int {
+	for i := range line {
+		for _, d := range mathDelimiters {
+			if !bytes.HasPrefix(line[i:], []byte(d.open)) || (i > 0 && line[i-1] == '\\') {
+				continue
+			}
+			if d.display || findMathClose(line[i:], d) >= 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// mathRenderer is a custom Goldmark renderer for math. Expressions are
+// rendered with KaTeX; invalid LaTeX is reported in diags at the opening
+// delimiter and rendered as its source.
+type mathRenderer struct {
+	math     *katexRenderer
+	filename string
+	diags    *Diagnostics
+}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, r.renderMathInline)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+	reg.Register(kindEquationRef, r.renderEquationRef)
+}
+
+func (r *mathRenderer) renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*mathInline)
+	class := "katex-inline"
+	if n.Display {
+		class = "katex-display"
+	}
+	r.write(w, source, "span", class, n.Start, n.Value.Value(source), n.Display, n.equation)
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*mathBlock)
+	if !n.Closed {
+		r.diags.Errorf(offsetPosition(r.filename, source, n.Start), "display math is never closed by %s", n.Delimiter)
+	}
+	var latex bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		latex.Write(line.Value(source))
+	}
+	r.write(w, source, "div", "katex-display", n.Start, bytes.TrimSpace(latex.Bytes()), true, n.equation)
+	w.WriteByte('\n')
+	return ast.WalkSkipChildren, nil
+}
+
+// write writes the rendering of latex in a tag of the given class. The
+// \label of a numbered equation is rendered as its number, and names the
+// tag. The source of an expression KaTeX cannot render is kept in
+// data-latex and shown as text.
+func (r *mathRenderer) write(w util.BufWriter, source []byte, tag, class string, start int, latex []byte, display bool, eq equation) {
+	tex := equationLabelRegex.ReplaceAllString(string(latex), "")
+	id := ""
+	if eq.Number > 0 {
+		tex += fmt.Sprintf(` \tag{%d}`, eq.Number)
+		id = fmt.Sprintf(` id="%s"`, util.EscapeHTML([]byte(eq.Label)))
+	}
+	rendered, err := r.math.render(tex, display)
+	if err != nil {
+		r.diags.Errorf(offsetPosition(r.filename, source, start), "invalid LaTeX: %s", err)
+		escaped := util.EscapeHTML(latex)
+		fmt.Fprintf(w, `<%s class="%s"%s data-latex="%s">%s</%s>`, tag, class, id, escaped, escaped, tag)
+		return
+	}
+	fmt.Fprintf(w, `<%s class="%s"%s>%s</%s>`, tag, class, id, rendered, tag)
+}
+
+// mathExtension is a Goldmark extension parsing, numbering and rendering
+// the math of the article at filename. Problems are reported in diags.
+type mathExtension struct {
+	math     *katexRenderer
+	filename string
+	diags    *Diagnostics
+}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 750)),
+		parser.WithInlineParsers(
+			util.Prioritized(&mathInlineParser{}, 600),
+			util.Prioritized(&equationRefParser{}, 610),
+		),
+		parser.WithASTTransformers(
+			util.Prioritized(&equationNumberer{filename: e.filename, diags: e.diags}, 90),
+			util.Prioritized(&htmlMathChecker{filename: e.filename, diags: e.diags}, 91),
+		),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathRenderer{math: e.math, filename: e.filename, diags: e.diags}, 50),
+	))
+}
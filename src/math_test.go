@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// convertMath renders markdown with the math extension, running the KaTeX
+// of this directory.
+func convertMath(t *testing.T, markdown string) (string, *Diagnostics) {
+	t.Helper()
+	math, err := katexFor(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags := &Diagnostics{}
+	md := goldmark.New(
+		goldmark.WithExtensions(&mathExtension{math: math, filename: "test.md", diags: diags}),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(markdown), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String(), diags
+}
+
+func TestMath(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		inline   int      // expressions rendered inline
+		display  int      // expressions KaTeX rendered in display mode
+		contains []string // in the HTML
+		diags    []string // diagnostics, in order
+	}{
+		{
+			name:     "prices",
+			markdown: "It costs $5 and $10, or $ 3 $ with spaces.",
+			contains: []string{"$5 and $10, or $ 3 $ with spaces."},
+		},
+		{
+			name:     "inline",
+			markdown: "Let $x^2$ and \\(y\\) be squares.",
+			inline:   2,
+		},
+		{
+			name:     "code span",
+			markdown: "Write `$x$` for math, and $x$ is not `in $code$`.",
+			inline:   1,
+			contains: []string{"<code>$x$</code>", "<code>in $code$</code>"},
+		},
+		{
+			name:     "math does not run into a code span",
+			markdown: "A $ sign `and$` more.",
+			contains: []string{"A $ sign <code>and$</code> more."},
+		},
+		{
+			name:     "escaped dollar",
+			markdown: "A \\$ sign and \\$x$ stay text, $y$ does not.",
+			inline:   1,
+			contains: []string{"A $ sign and $x$ stay text"},
+		},
+		{
+			name:     "indented code",
+			markdown: "Some code:\n\n    $x$ \\[y\\]\n",
+			contains: []string{"<pre><code>$x$ \\[y\\]\n</code></pre>"},
+		},
+		{
+			name:     "fenced code",
+			markdown: "```\n$$\nx\n$$\n```\n",
+			contains: []string{"<pre><code>$$\nx\n$$\n</code></pre>"},
+		},
+		{
+			name:     "display math mid-paragraph",
+			markdown: "The square \\[x^2\\] is positive.",
+			display:  1,
+			contains: []string{"<p>The square <span class=\"katex-display\">", "</span> is positive.</p>"},
+		},
+		{
+			name:     "display block",
+			markdown: "Text\n\\[\nx^2\n\\]\nmore text",
+			display:  1,
+			contains: []string{"<p>Text</p>\n<div class=\"katex-display\">", "</div>\n<p>more text</p>"},
+		},
+		{
+			name:     "single line display block",
+			markdown: "$$x^2$$\n",
+			display:  1,
+			contains: []string{"<div class=\"katex-display\">"},
+		},
+		{
+			name:     "unclosed display block",
+			markdown: "Text\n\n$$\nx^2\n",
+			display:  1,
+			diags:    []string{"test.md:3:1: error: display math is never closed by $$"},
+		},
+		{
+			name:     "unclosed inline math",
+			markdown: "An $x^2 left open, and \\(y too.",
+			contains: []string{"An $x^2 left open, and (y too."},
+		},
+		{
+			name:     "list",
+			markdown: "- $x$\n- item\n\n  \\[\n  y\n  \\]\n",
+			inline:   1,
+			display:  1,
+			contains: []string{"<li>\n<p><span class=\"katex-inline\">", "<div class=\"katex-display\">"},
+		},
+		{
+			name:     "blockquote",
+			markdown: "> $x$ and\n>\n> $$\n> y\n> $$\n",
+			inline:   1,
+			display:  1,
+			contains: []string{"<blockquote>\n<p><span class=\"katex-inline\">", "</div>\n</blockquote>"},
+		},
+		{
+			name:     "invalid LaTeX is escaped",
+			markdown: "Broken $\\frac{\"<a>\" & b$ math.",
+			inline:   1,
+			contains: []string{`data-latex="\frac{&quot;&lt;a&gt;&quot; &amp; b">\frac{&quot;&lt;a&gt;&quot; &amp; b</span>`},
+			diags:    []string{"test.md:1:8: error: invalid LaTeX"},
+		},
+		{
+			name:     "HTML block",
+			markdown: "<div>\n\\[x^2\\]\n</div>\n\n<div>\n\n\\[y^2\\]\n\n</div>\n",
+			display:  1,
+			contains: []string{"<div>\n\\[x^2\\]\n</div>"},
+			diags:    []string{"test.md:2:1: warning: math in an HTML block is not rendered"},
+		},
+		{
+			name:     "raw HTML block",
+			markdown: "<script>\nconst re = /\\[/;\n</script>\n",
+			contains: []string{"const re = /\\[/;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, diags := convertMath(t, tt.markdown)
+			if n := strings.Count(got, `class="katex-inline"`); n != tt.inline {
+				t.Errorf("%d inline expressions, want %d in:\n%s", n, tt.inline, got)
+			}
+			if n := strings.Count(got, `display="block"`); n != tt.display {
+				t.Errorf("%d display expressions, want %d in:\n%s", n, tt.display, got)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("missing %q in:\n%s", want, got)
+				}
+			}
+			items := diags.Items()
+			if len(items) != len(tt.diags) {
+				t.Fatalf("diagnostics = %v, want %q", items, tt.diags)
+			}
+			for i, want := range tt.diags {
+				if !strings.HasPrefix(items[i].Error(), want) {
+					t.Errorf("diagnostic %q, want %q", items[i].Error(), want)
+				}
+			}
+		})
+	}
+}
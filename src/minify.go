@@ -5,47 +5,132 @@ import (
 	"github.com/tdewolff/minify/v2"
 	// Using `css` identifier causes collisions everywhere
 	cssminifier "github.com/tdewolff/minify/v2/css"
+	htmlminifier "github.com/tdewolff/minify/v2/html"
 	jsminifier "github.com/tdewolff/minify/v2/js"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var m *minify.M
 
-// InitMinifier initialises the package-level minifier with CSS and JS handlers.
-// Must be called before any MinifyCSS or MinifyJS calls.
-func InitMinifier() {
-	m = minify.New()
-	m.AddFunc("text/css", cssminifier.Minify)
-	m.AddFunc("application/javascript", jsminifier.Minify)
+// minifiedFile is a memoized minification result, valid as long as the
+// source file keeps the same modification time and size.
+type minifiedFile struct {
+	modTime time.Time
+	size    int64
+	content string
 }
 
-// MinifyCSS reads the file at filepath and returns its minified CSS content.
-func MinifyCSS(filepath string) (string, error) {
-	content, err := os.ReadFile(filepath)
+// minifyCache memoizes minified files by path. Global assets such as
+// article.css or toc.js are inlined into many pages; they are minified once
+// per build (and once per change when serving) instead of once per page.
+var minifyCache = struct {
+	sync.Mutex
+	files map[string]minifiedFile
+}{files: make(map[string]minifiedFile)}
+
+// minifyFile reads the file at path and returns its content minified as
+// mediatype, reusing the memoized result when the file did not change.
+func minifyFile(path, mediatype string) (string, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		return "", err
 	}
-	minified, err := m.String("text/css", string(content))
+	key := mediatype + ":" + path
+
+	minifyCache.Lock()
+	cached, ok := minifyCache.files[key]
+	minifyCache.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.content, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	minified, err := m.String(mediatype, string(content))
 	if err != nil {
 		return string(content), err
 	}
+
+	minifyCache.Lock()
+	minifyCache.files[key] = minifiedFile{modTime: info.ModTime(), size: info.Size(), content: minified}
+	minifyCache.Unlock()
 	return minified, nil
 }
 
+// InitMinifier initialises the package-level minifier with CSS, JS and HTML
+// handlers. Must be called before any MinifyCSS, MinifyJS or MinifyHTML
+// calls.
+//
+// The HTML minifier keeps quotes, end tags and the document tags: attribute
+// values such as data-palette are read back verbatim by scripts, and <pre>
+// whitespace is always preserved.
+func InitMinifier() {
+	m = minify.New()
+	m.AddFunc("text/css", cssminifier.Minify)
+	m.AddFunc("application/javascript", jsminifier.Minify)
+	m.Add("text/html", &htmlminifier.Minifier{
+		KeepDocumentTags:    true,
+		KeepEndTags:         true,
+		KeepQuotes:          true,
+		KeepDefaultAttrVals: true,
+	})
+}
+
+// MinifyCSS reads the file at filepath and returns its minified CSS content.
+func MinifyCSS(filepath string) (string, error) {
+	return minifyFile(filepath, "text/css")
+}
+
 // MinifyJS reads the file at filepath and returns its minified JavaScript content.
 func MinifyJS(filepath string) (string, error) {
-	content, err := os.ReadFile(filepath)
-	if err != nil {
-		return "", err
+	return minifyFile(filepath, "application/javascript")
+}
+
+// MinifyHTML returns html minified, including its inline styles and scripts.
+func MinifyHTML(html string) (string, error) {
+	return m.String("text/html", html)
+}
+
+// htmlMinifier minifies every rendered page when Config.MinifyHTML is set,
+// and keeps track of the bytes saved.
+type htmlMinifier struct {
+	pages  atomic.Int64
+	before atomic.Int64
+	after  atomic.Int64
+}
+
+func (*htmlMinifier) Name() string { return "html minification" }
+
+func (h *htmlMinifier) Transform(site *Site, page Page, html string) (string, error) {
+	if !site.Config.MinifyHTML {
+		return html, nil
 	}
-	minified, err := m.String("application/javascript", string(content))
+	minified, err := MinifyHTML(html)
 	if err != nil {
-		return string(content), err
+		return "", err
 	}
+	h.pages.Add(1)
+	h.before.Add(int64(len(html)))
+	h.after.Add(int64(len(minified)))
 	return minified, nil
 }
 
+// Report returns the sizes of the pages minified since the last report.
+func (h *htmlMinifier) Report() string {
+	pages, before, after := h.pages.Swap(0), h.before.Swap(0), h.after.Swap(0)
+	if pages == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d pages, %.1f KiB -> %.1f KiB (-%.1f%%)",
+		pages, float64(before)/1024, float64(after)/1024, 100*float64(before-after)/float64(before))
+}
+
 // loadAndMinifyFileFromPaths tries each path in order, returning the minified
 // content of the first file found. Returns an error if none exist.
 func loadAndMinifyFileFromPaths(searchPaths []string, minifyFunc func(string) (string, error)) (string, error) {
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// newArticleOptions holds the fields of a new article. Empty fields are
+// prompted for interactively.
+type newArticleOptions struct {
+	Slug        string
+	Title       string
+	Description string
+	Tags        string // comma-separated
+	Bundle      bool   // create articles/<slug>/ instead of flat files
+}
+
+// validateSlug checks that slug is lowercase and hyphenated, and that no
+// article or bundle with that slug exists yet in articleDir.
+func validateSlug(articleDir, slug string) (string, error) {
+	slug = strings.TrimSpace(slug)
+	if slug == "" {
+		return "", fmt.Errorf("slug cannot be empty")
+	}
+	if !slugRegex.MatchString(slug) {
+		return "", fmt.Errorf("invalid slug %q: use only lowercase letters, digits, and hyphens, with no leading or trailing hyphens", slug)
+	}
+	for _, name := range []string{slug + ".json", slug + ".md", slug} {
+		path := filepath.Join(articleDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return "", fmt.Errorf("file already exists: %s", path)
+		}
+	}
+	return slug, nil
+}
+
+// validateNonEmpty trims value and rejects it if empty.
+func validateNonEmpty(field string) func(string) (string, error) {
+	return func(value string) (string, error) {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return "", fmt.Errorf("%s cannot be empty", field)
+		}
+		return value, nil
+	}
+}
+
+// validateTags splits a comma-separated list and checks every tag is one of
+// validTags.
+func validateTags(raw string, validTags []string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(raw, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("at least one tag is required")
+	}
+	for _, tag := range tags {
+		if !slices.Contains(validTags, tag) {
+			return nil, fmt.Errorf("unknown tag %q, valid tags are: %s", tag, strings.Join(validTags, ", "))
+		}
+	}
+	return tags, nil
+}
+
+// promptUntilValid asks for label on out until validate accepts the answer
+// read from in. A value already provided on the command line is validated
+// once, without prompting.
+func promptUntilValid(in *bufio.Reader, out io.Writer, label, provided string, validate func(string) (string, error)) (string, error) {
+	if provided != "" {
+		return validate(provided)
+	}
+	for {
+		fmt.Fprintf(out, "%s: ", label)
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("reading %s: %w", strings.ToLower(label), err)
+		}
+		value, verr := validate(line)
+		if verr == nil {
+			return value, nil
+		}
+		fmt.Fprintf(out, "Error: %v\n", verr)
+	}
+}
+
+// newArticle creates the manifest and an empty markdown file for a new
+// draft article in config.ArticleDir, or in a new bundle directory with
+// opts.Bundle.
+func newArticle(config Config, opts newArticleOptions, stdin io.Reader, out io.Writer) error {
+	in := bufio.NewReader(stdin)
+
+	slug, err := promptUntilValid(in, out, "Slug (lowercase-hyphenated)", opts.Slug, func(s string) (string, error) {
+		return validateSlug(config.ArticleDir, s)
+	})
+	if err != nil {
+		return err
+	}
+	title, err := promptUntilValid(in, out, "Title", opts.Title, validateNonEmpty("title"))
+	if err != nil {
+		return err
+	}
+	description, err := promptUntilValid(in, out, "Description", opts.Description, validateNonEmpty("description"))
+	if err != nil {
+		return err
+	}
+
+	var tags []string
+	validTags := config.Site.ArticleTags
+	_, err = promptUntilValid(in, out, "Tags (comma-separated: "+strings.Join(validTags, ", ")+")", opts.Tags, func(s string) (string, error) {
+		tags, err = validateTags(s, validTags)
+		return s, err
+	})
+	if err != nil {
+		return err
+	}
+
+	manifest := ArticleManifest{
+		Title:        title,
+		Date:         time.Now().Format(time.DateOnly),
+		Draft:        true,
+		Tags:         tags,
+		Author:       config.Site.Author.Name,
+		AuthorImage:  config.Site.Author.Image,
+		Description:  description,
+		MarkdownFile: slug + ".md",
+	}
+	jsonPath := filepath.Join(config.ArticleDir, slug+".json")
+	mdPath := filepath.Join(config.ArticleDir, slug+".md")
+	if opts.Bundle {
+		// The markdown of a bundle defaults to index.md.
+		manifest.MarkdownFile = ""
+		jsonPath = filepath.Join(config.ArticleDir, slug, bundleManifestFilename)
+		mdPath = filepath.Join(config.ArticleDir, slug, bundleMarkdownFilename)
+		if err := os.MkdirAll(filepath.Join(config.ArticleDir, slug), 0755); err != nil {
+			return err
+		}
+	}
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(jsonPath, append(content, '\n'), config.FileMode); err != nil {
+		return err
+	}
+	if err := os.WriteFile(mdPath, []byte("# "+title+"\n"), config.FileMode); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Created: %s\nCreated: %s\n", jsonPath, mdPath)
+	return nil
+}
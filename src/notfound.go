@@ -0,0 +1,60 @@
+package main
+
+import "github.com/a-h/templ"
+
+// notFoundRecentArticles is the number of articles listed on the 404 page.
+const notFoundRecentArticles = 3
+
+// knownPage is a page the 404 page may suggest, matched client-side
+// against the requested URL by notfound.js.
+type knownPage struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// knownPages returns the articles, then the static pages, that the 404
+// page may suggest.
+func knownPages(site *Site) []knownPage {
+	config := site.Config
+	var pages []knownPage
+	for _, a := range site.Articles {
+		pages = append(pages, knownPage{Title: a.Manifest.Title, URL: config.pageURL(a.HTMLFilename)})
+	}
+	return append(pages,
+		knownPage{Title: config.Site.Pages.Home.Title, URL: config.pageURL("index.html")},
+		knownPage{Title: config.Site.Pages.Articles.Title, URL: config.pageURL("articles.html")},
+		knownPage{Title: config.Site.Pages.Resume.Title, URL: config.pageURL("resume.html")},
+	)
+}
+
+// notFoundPage generates 404.html, served by Cloudflare Pages (and by
+// serve) for any URL matching no file. Since it is served at any depth,
+// its references are root-relative.
+type notFoundPage struct{}
+
+func (notFoundPage) Name() string { return "404 page" }
+
+func (notFoundPage) PostProcess(site *Site, out *outputWriter) error { return nil }
+
+func (notFoundPage) Pages(s *Site) []Page {
+	site := s.Config.Site
+	recent := s.Articles
+	if len(recent) > notFoundRecentArticles {
+		recent = recent[:notFoundRecentArticles]
+	}
+	known := knownPages(s)
+
+	return []Page{{
+		Filename: "404.html",
+		Assets: []Asset{
+			globalCSS("articles.css"),
+			globalCSS("notfound.css"),
+			globalJS("notfound.js"),
+		},
+		Inputs: []any{recent, known},
+		Render: func(styleTags, scriptTags []string) templ.Component {
+			return notFound(site, recent, known, styleTags, scriptTags)
+		},
+		RootRelative: true,
+	}}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stagedFile is a file written to the staging directory, waiting to be
+// moved into the output directory.
+type stagedFile struct {
+	hash string // build cache input hash, empty for uncached outputs
+}
+
+// outputWriter collects every file produced by a build in a staging
+// directory next to the output directory. Nothing in the output directory
+// changes until Commit is called, so a build failing halfway leaves the
+// previous site untouched. All methods are safe for concurrent use.
+type outputWriter struct {
+	outputDir  string
+	stagingDir string
+	mode       fs.FileMode
+	cache      *buildCache
+
+	mu      sync.Mutex
+	staged  map[string]stagedFile
+	removed []string
+}
+
+// newOutputWriter creates the staging directory for a build writing to
+// config.OutputDir. Input hashes of committed files are recorded in cache.
+func newOutputWriter(config Config, cache *buildCache) (*outputWriter, error) {
+	parent := filepath.Dir(config.OutputDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	// The staging directory lives on the same file system as the output
+	// directory so that committing is a rename.
+	stagingDir, err := os.MkdirTemp(parent, "."+filepath.Base(config.OutputDir)+"-staging-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	return &outputWriter{
+		outputDir:  config.OutputDir,
+		stagingDir: stagingDir,
+		mode:       config.FileMode,
+		cache:      cache,
+		staged:     make(map[string]stagedFile),
+	}, nil
+}
+
+// stagingPath returns the path of rel in the staging directory, creating
+// its parent directories.
+func (w *outputWriter) stagingPath(rel string) (string, error) {
+	p := filepath.Join(w.stagingDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// stage records that rel was written with the given input hash.
+func (w *outputWriter) stage(rel, hash string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.staged[rel] = stagedFile{hash: hash}
+}
+
+// WriteFile stages data as the output file rel. hash is the input hash
+// recorded in the build cache on commit; pass "" for uncached outputs.
+func (w *outputWriter) WriteFile(rel string, data []byte, hash string) error {
+	p, err := w.stagingPath(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, data, w.mode); err != nil {
+		return fmt.Errorf("failed to write %s: %v", rel, err)
+	}
+	w.stage(rel, hash)
+	return nil
+}
+
+// CopyFile stages a copy of src as the output file rel.
+func (w *outputWriter) CopyFile(rel, src, hash string) error {
+	p, err := w.stagingPath(rel)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(src, p, w.mode); err != nil {
+		return fmt.Errorf("failed to copy %s: %v", src, err)
+	}
+	w.stage(rel, hash)
+	return nil
+}
+
+// Remove schedules the removal of the output file rel on commit.
+func (w *outputWriter) Remove(rel string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.removed = append(w.removed, rel)
+}
+
+// Commit swaps the staging directory in for the output directory, and
+// deletes the previous one. Output files that were neither staged nor
+// removed are first carried over into the staging directory as hard links,
+// so that the swap is two renames: the output directory holds either the
+// previous site or the new one, never a mix of both. If the second rename
+// fails, the previous output directory is put back.
+func (w *outputWriter) Commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.carryOver(); err != nil {
+		return fmt.Errorf("failed to carry over %s: %v", w.outputDir, err)
+	}
+
+	// os.MkdirTemp creates the staging directory readable by its owner
+	// only, while the site is served by other users.
+	if err := os.Chmod(w.stagingDir, 0755); err != nil {
+		return fmt.Errorf("failed to set the mode of the staging directory: %v", err)
+	}
+
+	previous := w.stagingDir + "-previous"
+	if err := os.Rename(w.outputDir, previous); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move %s aside: %v", w.outputDir, err)
+	}
+	if err := os.Rename(w.stagingDir, w.outputDir); err != nil {
+		os.Rename(previous, w.outputDir)
+		return fmt.Errorf("failed to move the staging directory into %s: %v", w.outputDir, err)
+	}
+
+	for rel, file := range w.staged {
+		if file.hash != "" {
+			w.cache.storeOutput(rel, file.hash)
+		}
+	}
+	return os.RemoveAll(previous)
+}
+
+// carryOver links every file of the output directory that is neither
+// staged nor scheduled for removal into the staging directory, copying it
+// when it cannot be linked.
+func (w *outputWriter) carryOver() error {
+	removed := make(map[string]bool, len(w.removed))
+	for _, rel := range w.removed {
+		removed[rel] = true
+	}
+	err := filepath.WalkDir(w.outputDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(w.outputDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := w.staged[rel]; ok || removed[rel] {
+			return nil
+		}
+		dst, err := w.stagingPath(rel)
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		}
+		if err := os.Link(p, dst); err != nil {
+			return copyFile(p, dst, w.mode)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Discard deletes the staging directory without touching the output
+// directory.
+func (w *outputWriter) Discard() error {
+	return os.RemoveAll(w.stagingDir)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// paletteColorCount is the number of colors of an image palette.
+	paletteColorCount = 10
+
+	// paletteSampleStep samples one pixel out of paletteSampleStep: the
+	// palette of a large image does not need every pixel.
+	paletteSampleStep = 10
+
+	// paletteMinSpread is the smallest spread of a channel worth splitting a
+	// box on: closer colors are not told apart.
+	paletteMinSpread = 8
+)
+
+// colorBox is a set of pixels of a median cut.
+type colorBox [][3]uint8
+
+// widestChannel returns the channel (0 for red, 1 for green, 2 for blue)
+// whose values are the most spread in the box, and that spread.
+func (b colorBox) widestChannel() (int, int) {
+	channel, spread := 0, 0
+	for c := 0; c < 3; c++ {
+		lo, hi := uint8(255), uint8(0)
+		for _, p := range b {
+			lo, hi = min(lo, p[c]), max(hi, p[c])
+		}
+		if int(hi)-int(lo) > spread {
+			channel, spread = c, int(hi)-int(lo)
+		}
+	}
+	return channel, spread
+}
+
+// average returns the mean color of the box.
+func (b colorBox) average() color.RGBA {
+	var sum [3]int
+	for _, p := range b {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b)
+	return color.RGBA{R: uint8(sum[0] / n), G: uint8(sum[1] / n), B: uint8(sum[2] / n), A: 255}
+}
+
+// imagePalette returns up to count main colors of the image at src, the
+// most common first, by median cut: a box of pixels is split in two at the
+// median of its widest channel until there are count boxes, or none is
+// worth splitting. Mostly transparent and nearly white pixels, the
+// background of most drawings, are ignored.
+func imagePalette(src string, count int) ([]color.RGBA, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	img, _, err := image.Decode(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var pixels colorBox
+	bounds := img.Bounds()
+	for i := 0; i < bounds.Dx()*bounds.Dy(); i += paletteSampleStep {
+		x, y := bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A < 125 || (c.R > 250 && c.G > 250 && c.B > 250) {
+			continue
+		}
+		pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+	}
+	if len(pixels) == 0 {
+		return nil, nil
+	}
+
+	boxes := []colorBox{pixels}
+	for len(boxes) < count {
+		// Splitting the box with the most pixels spread over the widest
+		// range keeps large uniform areas from taking the whole palette.
+		split, best := -1, 0
+		for i, box := range boxes {
+			if _, spread := box.widestChannel(); spread >= paletteMinSpread && spread*len(box) > best {
+				split, best = i, spread*len(box)
+			}
+		}
+		if split < 0 {
+			break
+		}
+		box := boxes[split]
+		channel, _ := box.widestChannel()
+		sort.Slice(box, func(i, j int) bool { return box[i][channel] < box[j][channel] })
+		// Pixels sharing the median value stay together, on the side
+		// closest to the median: splitting a uniform area in two would
+		// yield the same color twice.
+		median := box[len(box)/2][channel]
+		lo := sort.Search(len(box), func(i int) bool { return box[i][channel] >= median })
+		hi := sort.Search(len(box), func(i int) bool { return box[i][channel] > median })
+		cut := hi
+		if lo > 0 && (hi == len(box) || len(box)/2-lo < hi-len(box)/2) {
+			cut = lo
+		}
+		boxes[split] = box[:cut]
+		boxes = append(boxes, box[cut:])
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool { return len(boxes[i]) > len(boxes[j]) })
+	palette := make([]color.RGBA, len(boxes))
+	for i, box := range boxes {
+		palette[i] = box.average()
+	}
+	return palette, nil
+}
+
+// paletteAttributes returns the data-dominant-color and data-palette
+// attributes of the image dest, as hex colors, from which theme.js adjusts
+// the colors of dynamic color images.
+func (r *imageResolver) paletteAttributes(dest string) string {
+	img, ok := r.resolve(dest)
+	if !ok {
+		r.diags.Warnf(Position{File: r.filename}, "dynamic color image %s is not a readable local PNG or JPEG image: its colors will not be adjusted", dest)
+		return ""
+	}
+	palette, err := imagePalette(img.Src, paletteColorCount)
+	if err != nil {
+		r.diags.Warnf(Position{File: r.filename}, "cannot read image %s: %v", dest, err)
+		return ""
+	}
+	if len(palette) == 0 {
+		return ""
+	}
+
+	colors := make([]string, len(palette))
+	for i, c := range palette {
+		colors[i] = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf(` data-dominant-color="%s" data-palette="%s"`, colors[0], strings.Join(colors, " "))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Site is the build context handed to generators and transformers: the
+// configuration, the parsed content, and the published assets.
+type Site struct {
+	Config      Config
+	Articles    []Article
+	Experiences ExperiencesData
+	Assets      AssetManifest
+}
+
+// Generator is a self-contained feature contributing to the site. Pages
+// returns the pages it adds, which are rendered (and cached) like every
+// other page. PostProcess runs once every page was rendered and may stage
+// additional files in out, such as a sitemap, a feed or a search index.
+type Generator interface {
+	Name() string
+	Pages(site *Site) []Page
+	PostProcess(site *Site, out *outputWriter) error
+}
+
+// Transformer rewrites the HTML of every rendered page before it is
+// written. Transformers run in registration order.
+type Transformer interface {
+	Name() string
+	Transform(site *Site, page Page, html string) (string, error)
+}
+
+// Reporter is implemented by transformers that summarize their work, such
+// as the bytes saved by minification. Report returns what happened since
+// the previous call, or "" when there is nothing to report.
+type Reporter interface {
+	Report() string
+}
+
+// generators and transformers list, in order, every feature of the site.
+// Adding a page type or an output file means adding an entry here.
+var (
+	generators = []Generator{
+		staticPages{},
+		articlePages{},
+		aliasPages{},
+		notFoundPage{},
+		sitemapGenerator{},
+		headersGenerator{},
+		redirectsGenerator{},
+	}
+
+	transformers = []Transformer{
+		&cssPruner{},
+		assetReferenceRewriter{},
+		&htmlMinifier{},
+		cspGenerator{},
+	}
+)
+
+// Pages returns the pages of every generator, in registration order.
+func (s *Site) Pages() []Page {
+	var pages []Page
+	for _, g := range generators {
+		pages = append(pages, g.Pages(s)...)
+	}
+	return pages
+}
+
+// checkPageFilenames reports two pages, from the same generator or not,
+// being written to the same file.
+func checkPageFilenames(site *Site) error {
+	owners := make(map[string]string)
+	for _, g := range generators {
+		for _, page := range g.Pages(site) {
+			if owner, exists := owners[page.Filename]; exists {
+				return fmt.Errorf("page collision: %s and %s both generate %s", owner, g.Name(), page.Filename)
+			}
+			owners[page.Filename] = g.Name()
+		}
+	}
+	return nil
+}
+
+// transformHTML runs every transformer on the rendered HTML of page.
+func transformHTML(site *Site, page Page, html string) (string, error) {
+	for _, t := range transformers {
+		var err error
+		html, err = t.Transform(site, page, html)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", t.Name(), err)
+		}
+	}
+	return html, nil
+}
+
+// reportTransformers logs the summary of every transformer implementing
+// Reporter. Quiet drops the summaries, which are reset all the same.
+func reportTransformers(quiet bool) {
+	for _, t := range transformers {
+		if r, ok := t.(Reporter); ok {
+			if report := r.Report(); report != "" && !quiet {
+				log.Printf("%s: %s", t.Name(), report)
+			}
+		}
+	}
+}
+
+// postProcessing runs the PostProcess hook of every generator, once all
+// pages are rendered.
+func postProcessing(site *Site, out *outputWriter) error {
+	for _, g := range generators {
+		if err := g.PostProcess(site, out); err != nil {
+			return fmt.Errorf("%s: %v", g.Name(), err)
+		}
+	}
+	return nil
+}
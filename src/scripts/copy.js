@@ -49,7 +49,13 @@ function getCodeText(codeBlock) {
 document.addEventListener('click', function(event) {
   const filenameButton = event.target.closest('.copy-filename');
   if (filenameButton) {
-    showCopyNotification(filenameButton);
+    navigator.clipboard.writeText(filenameButton.dataset.filename)
+      .then(() => {
+        showCopyNotification(filenameButton);
+      })
+      .catch(err => {
+        console.error('Could not copy filename: ', err);
+      });
     return;
   }
   
@@ -0,0 +1,99 @@
+// Suggests the known page closest to the requested URL, and searches known
+// pages by title. The list of known pages is generated at build time.
+const knownPages = JSON.parse(
+  document.getElementById("known-pages").textContent,
+);
+
+// slugOf returns the last segment of a URL path, without extension:
+// "/posts/my-article.html" and "/my-article/" both give "my-article".
+function slugOf(path) {
+  const segments = path.split("/").filter((segment) => segment !== "");
+  if (segments.length === 0) {
+    return "";
+  }
+  return decodeURIComponent(segments[segments.length - 1])
+    .replace(/\.html$/, "")
+    .toLowerCase();
+}
+
+function levenshtein(a, b) {
+  let previous = Array.from({ length: b.length + 1 }, (_, j) => j);
+  for (let i = 1; i <= a.length; i++) {
+    const current = [i];
+    for (let j = 1; j <= b.length; j++) {
+      const substitution = previous[j - 1] + (a[i - 1] === b[j - 1] ? 0 : 1);
+      current.push(Math.min(previous[j] + 1, current[j - 1] + 1, substitution));
+    }
+    previous = current;
+  }
+  return previous[b.length];
+}
+
+// similarity is 1 for identical strings and 0 for strings with nothing in
+// common.
+function similarity(a, b) {
+  const length = Math.max(a.length, b.length);
+  return length === 0 ? 1 : 1 - levenshtein(a, b) / length;
+}
+
+// closestPage returns the known page whose slug is the most similar to the
+// requested one, or null when none is similar enough.
+function closestPage(requested) {
+  let best = null;
+  let bestScore = 0.5;
+  knownPages.forEach(function (page) {
+    const slug = slugOf(page.url);
+    if (slug === "") {
+      return;
+    }
+    const score = similarity(requested, slug);
+    if (score > bestScore) {
+      best = page;
+      bestScore = score;
+    }
+  });
+  return best;
+}
+
+function showSuggestion() {
+  const requested = slugOf(window.location.pathname);
+  const page = requested === "" ? null : closestPage(requested);
+  if (page === null) {
+    return;
+  }
+  const suggestion = document.querySelector(".not-found-suggestion");
+  const link = suggestion.querySelector("a");
+  link.href = page.url;
+  link.textContent = page.title;
+  suggestion.hidden = false;
+}
+
+function showResults(query) {
+  const results = document.querySelector(".not-found-results");
+  results.innerHTML = "";
+  const words = query.toLowerCase().split(/\s+/).filter((word) => word !== "");
+  if (words.length === 0) {
+    return;
+  }
+  knownPages
+    .filter(function (page) {
+      const haystack = (page.title + " " + slugOf(page.url)).toLowerCase();
+      return words.every((word) => haystack.includes(word));
+    })
+    .forEach(function (page) {
+      const item = document.createElement("li");
+      const link = document.createElement("a");
+      link.href = page.url;
+      link.textContent = page.title;
+      item.appendChild(link);
+      results.appendChild(item);
+    });
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+  showSuggestion();
+  const search = document.querySelector(".not-found-search");
+  search.addEventListener("input", function () {
+    showResults(search.value);
+  });
+});
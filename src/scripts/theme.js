@@ -562,8 +562,6 @@ function parseColorToRGB(colorStr) {
 }
 
 function adjustImagesColors() {
-  const colorThief = new ColorThief();
-
   const rootStyles = window.getComputedStyle(document.documentElement);
   let bgColor = rootStyles.getPropertyValue("--bg").trim();
   bgColor = parseColorToRGB(bgColor);
@@ -573,25 +571,21 @@ function adjustImagesColors() {
     b: bgColor[2],
   };
 
-  const images = document.querySelectorAll("img.dynamic-colors");
+  // The dominant color of each image is computed when building the site.
+  const images = document.querySelectorAll(
+    "img.dynamic-colors[data-dominant-color]",
+  );
 
   for (const image of images) {
-    const colors = colorThief.getPalette(image);
-    const dominantColor = {
-      r: colors[0][0],
-      g: colors[0][1],
-      b: colors[0][2],
-    };
-
-    console.log(dominantColor);
-
+    const dominantColor = hexToRgb(image.dataset.dominantColor);
     const contrastRatio = computeContrastRatio(dominantColor, bgColor);
 
+    let optimalShift = 0;
     if (contrastRatio < 7.0) {
-      const optimalShift = findOptimalHueShift(dominantColor, bgColor);
-
-      applyHueShiftToImage(image, optimalShift);
+      optimalShift = findOptimalHueShift(dominantColor, bgColor);
     }
+
+    applyHueShiftToImage(image, optimalShift);
   }
 }
 
@@ -685,48 +679,60 @@ function computeContrastWithHue(saturation, lightness, newHue, bgColor) {
 }
 
 /**
- * Apply the hue shift to an image using canvas
+ * Apply the hue shift to an image using canvas. The shift is relative to the
+ * original image, which is restored when the shift is 0.
  * @param {HTMLImageElement} image - The image element to modify
  * @param {number} hueShift - The hue shift in degrees to apply
  */
 function applyHueShiftToImage(image, hueShift) {
-  if (hueShift === 0) return;
+  if (!image.dataset.originalSrc) {
+    if (hueShift === 0) return;
 
-  const canvas = document.createElement("canvas");
-  const ctx = canvas.getContext("2d");
+    // The shifted copy replaces the image, which its srcset would override.
+    image.dataset.originalSrc = image.currentSrc || image.src;
+    image.removeAttribute("srcset");
+    image.removeAttribute("sizes");
+  }
 
-  canvas.width = image.naturalWidth;
-  canvas.height = image.naturalHeight;
+  if (hueShift === 0) {
+    image.src = image.dataset.originalSrc;
+    return;
+  }
 
-  ctx.drawImage(image, 0, 0);
+  const original = new Image();
+  original.crossOrigin = image.crossOrigin;
+  original.addEventListener("load", function () {
+    const canvas = document.createElement("canvas");
+    const ctx = canvas.getContext("2d");
 
-  const imageData = ctx.getImageData(0, 0, canvas.width, canvas.height);
-  const data = imageData.data;
+    canvas.width = original.naturalWidth;
+    canvas.height = original.naturalHeight;
 
-  for (let i = 0; i < data.length; i += 4) {
-    const r = data[i];
-    const g = data[i + 1];
-    const b = data[i + 2];
-    const a = data[i + 3];
+    ctx.drawImage(original, 0, 0);
 
-    if (a === 0) continue;
+    const imageData = ctx.getImageData(0, 0, canvas.width, canvas.height);
+    const data = imageData.data;
 
-    const [h, s, l] = rgbToHsl(r, g, b);
-    const newHue = (h + hueShift) % 360;
-    const [newR, newG, newB] = hslToRgb(newHue, s, l);
+    for (let i = 0; i < data.length; i += 4) {
+      const r = data[i];
+      const g = data[i + 1];
+      const b = data[i + 2];
+      const a = data[i + 3];
 
-    data[i] = newR;
-    data[i + 1] = newG;
-    data[i + 2] = newB;
-  }
+      if (a === 0) continue;
 
-  ctx.putImageData(imageData, 0, 0);
+      const [h, s, l] = rgbToHsl(r, g, b);
+      const newHue = (h + hueShift) % 360;
+      const [newR, newG, newB] = hslToRgb(newHue, s, l);
 
-  const dataURL = canvas.toDataURL();
+      data[i] = newR;
+      data[i + 1] = newG;
+      data[i + 2] = newB;
+    }
 
-  if (!image.dataset.originalSrc) {
-    image.dataset.originalSrc = image.src;
-  }
+    ctx.putImageData(imageData, 0, 0);
 
-  image.src = dataURL;
+    image.src = canvas.toDataURL();
+  });
+  original.src = image.dataset.originalSrc;
 }
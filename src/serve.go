@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	liveReloadEventsPath = "/__livereload"
+	liveReloadScriptPath = "/__livereload.js"
+
+	// rebuildDebounce groups the bursts of events editors emit on save
+	// (write, chmod, rename...) into a single rebuild.
+	rebuildDebounce = 100 * time.Millisecond
+)
+
+// liveReloadScript is served at liveReloadScriptPath and injected into every
+// HTML page served by the development server.
+const liveReloadScript = `(function () {
+  const source = new EventSource("` + liveReloadEventsPath + `");
+  source.addEventListener("reload", function () {
+    window.location.reload();
+  });
+})();
+`
+
+// liveReload broadcasts reload events to every connected browser using
+// server-sent events.
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: make(map[chan struct{}]struct{})}
+}
+
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[ch] = struct{}{}
+	lr.mu.Unlock()
+
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, ch)
+		lr.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "event: reload\ndata: {}\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// broadcast notifies every connected client that it should reload.
+func (lr *liveReload) broadcast() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for ch := range lr.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// devServer holds the state of the development server: the last parsed
+// articles and experiences, so that a change only rebuilds what it affects.
+type devServer struct {
+	site   *Site
+	cache  *buildCache
+	reload *liveReload
+}
+
+// serveHTML serves HTML files from the output directory with the live
+// reload script injected before </body>. Like Cloudflare Pages, it answers
+// URLs matching no file with 404.html. Everything else is delegated to a
+// plain file server.
+func (s *devServer) serveHTML(files http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		urlPath := r.URL.Path
+		if strings.HasSuffix(urlPath, "/") {
+			urlPath += "index.html"
+		}
+		status := http.StatusOK
+		if _, err := os.Stat(filepath.Join(s.site.Config.OutputDir, filepath.FromSlash(urlPath))); os.IsNotExist(err) {
+			urlPath = "/404.html"
+			status = http.StatusNotFound
+		}
+		if filepath.Ext(urlPath) != ".html" {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		content, err := os.ReadFile(filepath.Join(s.site.Config.OutputDir, filepath.FromSlash(urlPath)))
+		if err != nil {
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		tag := []byte(`<script src="` + liveReloadScriptPath + `"></script>`)
+		if idx := bytes.LastIndex(content, []byte("</body>")); idx >= 0 {
+			content = append(content[:idx], append(tag, content[idx:]...)...)
+		} else {
+			content = append(content, tag...)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		w.Write(content)
+	})
+}
+
+// watchRecursively adds root and all of its subdirectories to the watcher.
+func watchRecursively(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// findArticleByFile returns the index in s.site.Articles of the article whose
+// manifest, markdown, CSS or script file is filename, relative to the
+// article directory, or -1.
+func (s *devServer) findArticleByFile(filename string) int {
+	slashed := filepath.ToSlash(filename)
+	for i, a := range s.site.Articles {
+		m := a.Manifest
+		if a.ManifestFilename == filename || m.MarkdownFile == slashed || m.CssFile == slashed || m.ScriptFile == slashed {
+			return i
+		}
+	}
+	return -1
+}
+
+// manifestForMarkdown scans articleDir and its bundles for the manifest
+// referencing the given markdown file. Used for drafts and new articles
+// that are not yet part of s.site.Articles.
+func manifestForMarkdown(articleDir, markdownFile string) string {
+	manifestFilenames, err := listArticleManifests(articleDir)
+	if err != nil {
+		return ""
+	}
+	for _, manifestFilename := range manifestFilenames {
+		manifest, err := loadArticleManifest(articleDir, manifestFilename)
+		if err == nil && manifest.MarkdownFile == filepath.ToSlash(markdownFile) {
+			return manifestFilename
+		}
+	}
+	return ""
+}
+
+// rebuildArticle re-parses a single article and returns the filenames of
+// the pages that need to be re-rendered as a consequence. The page of a
+// deleted article is removed when out is committed. An article that fails
+// to parse keeps its previous version in the site, so that its page stays
+// listed until the error is fixed.
+func (s *devServer) rebuildArticle(out *outputWriter, manifestFilename string) ([]string, error) {
+	idx := -1
+	for i, a := range s.site.Articles {
+		if a.ManifestFilename == manifestFilename {
+			idx = i
+		}
+	}
+
+	// The page and redirect stubs of the previous version of the article,
+	// and the other articles.
+	var stale []string
+	others := slices.Clone(s.site.Articles)
+	if idx >= 0 {
+		previous := s.site.Articles[idx]
+		stale = append(stale, s.site.Config.pageFilename(previous.HTMLFilename))
+		for _, alias := range previous.Manifest.Aliases {
+			stale = append(stale, aliasFilename(alias))
+		}
+		others = slices.Delete(others, idx, idx+1)
+	}
+	removeStale := func(keep []string) {
+		for _, filename := range stale {
+			if !slices.Contains(keep, filename) {
+				out.Remove(filename)
+			}
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.site.Config.ArticleDir, manifestFilename)); os.IsNotExist(err) {
+		s.site.Articles = others
+		removeStale(nil)
+		return s.articleListFilenames(), nil
+	}
+
+	var diags Diagnostics
+	a := parseArticle(s.site.Config, manifestFilename, s.cache, &diags)
+	if a != nil {
+		checkArticleAliases(s.site.Config, append(slices.Clone(others), *a), &diags)
+	}
+	diags.Print(os.Stderr)
+	if diags.HasErrors() {
+		return nil, errors.New(diags.Summary())
+	}
+	if a == nil {
+		// The article became a draft, or is a broken draft being skipped.
+		s.site.Articles = others
+		removeStale(nil)
+		return s.articleListFilenames(), nil
+	}
+
+	s.site.Articles = append(others, *a)
+	sortArticles(s.site.Articles)
+	// The article may use new images, or new versions of them.
+	if err := publishImageVariants(s.site.Config, out, s.cache, s.site.Articles, s.site.Assets); err != nil {
+		return nil, err
+	}
+	pages := append(s.articleListFilenames(), s.site.Config.pageFilename(a.HTMLFilename))
+	for _, alias := range a.Manifest.Aliases {
+		pages = append(pages, aliasFilename(alias))
+	}
+	removeStale(pages)
+	return pages, nil
+}
+
+// handleArticleChange maps a change in the article directory to the pages
+// that must be regenerated.
+func (s *devServer) handleArticleChange(out *outputWriter, path string) ([]string, error) {
+	filename, err := filepath.Rel(s.site.Config.ArticleDir, path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := filepath.Ext(filename); {
+	case isArticleManifest(filename):
+		return s.rebuildArticle(out, filename)
+	case ext == ".md":
+		if idx := s.findArticleByFile(filename); idx >= 0 {
+			return s.rebuildArticle(out, s.site.Articles[idx].ManifestFilename)
+		}
+		if manifest := manifestForMarkdown(s.site.Config.ArticleDir, filename); manifest != "" {
+			return s.rebuildArticle(out, manifest)
+		}
+	case ext == ".css", ext == ".js", articleBundle(filename) != "":
+		// The files of a bundle are published like assets.
+		if err := s.rebuildArticlesUsingImage(out, path); err != nil {
+			return nil, err
+		}
+		assets, err := publishAssets(s.site.Config, out, s.cache, s.site.Articles)
+		if err != nil {
+			return nil, err
+		}
+		s.site.Assets = assets
+		// Publishing changes the fingerprinted path of the file, which every
+		// page hash depends on.
+		return s.allPageFilenames(), nil
+	}
+	return nil, nil
+}
+
+// rebuildArticlesUsingImage parses the articles using the image at path
+// again: its size and variants are rendered into them.
+func (s *devServer) rebuildArticlesUsingImage(out *outputWriter, path string) error {
+	for _, a := range slices.Clone(s.site.Articles) {
+		if slices.ContainsFunc(a.Images, func(img ArticleImage) bool { return img.Src == path }) {
+			if _, err := s.rebuildArticle(out, a.ManifestFilename); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handleSrcChange maps a change in the source directory to the pages that
+// must be regenerated. A nil slice with a nil error means nothing to render.
+func (s *devServer) handleSrcChange(out *outputWriter, path string) ([]string, error) {
+	rel, err := filepath.Rel(s.site.Config.SrcDir, path)
+	if err != nil {
+		return nil, err
+	}
+	topDir := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+
+	switch {
+	case rel == "experiences.json":
+		experiences, err := loadExperiencesFromJSON(path)
+		if err != nil {
+			return nil, err
+		}
+		s.site.Experiences = *experiences
+		return s.pageFilenames("resume.html", "resume-printable.html"), nil
+	case filepath.Ext(rel) == ".go" || filepath.Ext(rel) == ".templ" || rel == "site.json":
+		log.Printf("%s changed: restart the server to pick up code, template and site configuration changes", rel)
+		return nil, nil
+	}
+
+	if err := s.rebuildArticlesUsingImage(out, path); err != nil {
+		return nil, err
+	}
+	if rel == filepath.Join("libs", "katex", "katex.js") {
+		// Math is rendered at build time: every article is parsed again
+		// with the new KaTeX.
+		for _, a := range slices.Clone(s.site.Articles) {
+			if _, err := s.rebuildArticle(out, a.ManifestFilename); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, dir := range append([]string{"css"}, append(fingerprintedDirs, verbatimDirs...)...) {
+		if topDir == dir {
+			assets, err := publishAssets(s.site.Config, out, s.cache, s.site.Articles)
+			if err != nil {
+				return nil, err
+			}
+			s.site.Assets = assets
+			// Global styles and scripts may be inlined in pages, and every
+			// page hash depends on the published asset paths: the build
+			// cache sorts out which pages actually need rendering.
+			return s.allPageFilenames(), nil
+		}
+	}
+	return nil, nil
+}
+
+// pageFilenames returns the output files of the pages with the given
+// names, such as "articles.html".
+func (s *devServer) pageFilenames(names ...string) []string {
+	filenames := make([]string, len(names))
+	for i, name := range names {
+		filenames[i] = s.site.Config.pageFilename(name)
+	}
+	return filenames
+}
+
+// articleListFilenames returns the output files of the pages listing
+// articles, which change whenever an article does.
+func (s *devServer) articleListFilenames() []string {
+	return append(s.pageFilenames("index.html", "articles.html"), "404.html")
+}
+
+// allPageFilenames returns the filenames of every page of the site.
+func (s *devServer) allPageFilenames() []string {
+	var filenames []string
+	for _, page := range s.site.Pages() {
+		filenames = append(filenames, page.Filename)
+	}
+	return filenames
+}
+
+// rebuild regenerates the pages affected by the changed paths. It returns
+// true when at least one output file was updated. Like build, it stages
+// its outputs and leaves the output directory untouched when it fails.
+func (s *devServer) rebuild(changed map[string]struct{}) (bool, error) {
+	out, err := newOutputWriter(s.site.Config, s.cache)
+	if err != nil {
+		return false, err
+	}
+	defer out.Discard()
+
+	// Published asset paths must keep pointing at committed files if the
+	// rebuild fails after publishing.
+	previousAssets := publishedAssets
+	updated, err := s.rebuildInto(out, changed)
+	if err == nil && updated {
+		err = out.Commit()
+	}
+	if err != nil {
+		publishedAssets = previousAssets
+		s.site.Assets = previousAssets
+		return false, err
+	}
+	if updated {
+		if err := s.cache.save(); err != nil {
+			return true, err
+		}
+	}
+	return updated, nil
+}
+
+// rebuildInto stages the outputs affected by the changed paths in out.
+func (s *devServer) rebuildInto(out *outputWriter, changed map[string]struct{}) (bool, error) {
+	var filenames []string
+	updated := false
+
+	for path := range changed {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			continue
+		}
+
+		var pages []string
+		var err error
+		if strings.HasPrefix(path, s.site.Config.ArticleDir+string(filepath.Separator)) {
+			pages, err = s.handleArticleChange(out, path)
+		} else {
+			pages, err = s.handleSrcChange(out, path)
+		}
+		if err != nil {
+			return updated, fmt.Errorf("%s: %w", path, err)
+		}
+		if pages != nil {
+			updated = true
+		}
+		filenames = append(filenames, pages...)
+	}
+
+	if len(filenames) == 0 {
+		return updated, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, filename := range filenames {
+		wanted[filename] = true
+	}
+
+	var pages []Page
+	for _, page := range s.site.Pages() {
+		if wanted[page.Filename] {
+			pages = append(pages, page)
+		}
+	}
+
+	if err := generatePages(s.site, out, pages, s.cache); err != nil {
+		return updated, err
+	}
+	if err := postProcessing(s.site, out); err != nil {
+		return updated, err
+	}
+	return true, nil
+}
+
+// watch listens for file system events on the article and source
+// directories, rebuilds what changed, and notifies connected browsers.
+func (s *devServer) watch(watcher *fsnotify.Watcher) {
+	changed := make(map[string]struct{})
+	timer := time.NewTimer(rebuildDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchRecursively(watcher, event.Name); err != nil {
+						log.Printf("Warning: cannot watch %s: %v", event.Name, err)
+					}
+				}
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			changed[event.Name] = struct{}{}
+			timer.Reset(rebuildDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Warning: watcher error: %v", err)
+		case <-timer.C:
+			start := time.Now()
+			updated, err := s.rebuild(changed)
+			changed = make(map[string]struct{})
+			if err != nil {
+				log.Printf("Rebuild failed: %v", err)
+				continue
+			}
+			if updated {
+				log.Printf("Rebuilt in %s", time.Since(start).Round(time.Millisecond))
+				s.reload.broadcast()
+			}
+		}
+	}
+}
+
+// serve builds the site once, then serves the output directory over HTTP
+// while watching the article and source directories for changes. Pages
+// affected by a change are regenerated and open browsers reload themselves.
+func serve(config Config, cache *buildCache, addr string) error {
+	site, err := build(config, cache)
+	if err != nil {
+		return err
+	}
+
+	s := &devServer{
+		site:   site,
+		cache:  cache,
+		reload: newLiveReload(),
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %v", err)
+	}
+	defer watcher.Close()
+
+	for _, dir := range []string{config.ArticleDir, config.SrcDir} {
+		if err := watchRecursively(watcher, dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %v", dir, err)
+		}
+	}
+	go s.watch(watcher)
+
+	mux := http.NewServeMux()
+	mux.Handle(liveReloadEventsPath, s.reload)
+	mux.HandleFunc(liveReloadScriptPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/javascript")
+		io.WriteString(w, liveReloadScript)
+	})
+	mux.Handle("/", s.serveHTML(http.FileServer(http.Dir(config.OutputDir))))
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	log.Printf("Serving %s on http://%s", config.OutputDir, net.JoinHostPort(host, port))
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SiteAuthor describes the person the site is about.
+type SiteAuthor struct {
+	Name     string `json:"name"`
+	JobTitle string `json:"jobTitle"`
+	Location string `json:"location"`
+	Bio      string `json:"bio"`
+	Email    string `json:"email"`
+	Image    string `json:"image"` // filename under images/
+}
+
+// SiteLink is an external profile link, shown in the footer and/or on the
+// resume.
+type SiteLink struct {
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+	Icon   string `json:"icon"` // Font Awesome classes
+	Footer bool   `json:"footer,omitempty"`
+	Resume bool   `json:"resume,omitempty"`
+}
+
+// NavLink is an entry of the navbar.
+type NavLink struct {
+	Label string `json:"label"`
+	Href  string `json:"href"`
+}
+
+// Redirect is a rule of the _redirects file deployed to Cloudflare Pages.
+// From and To may use splats and placeholders, as documented by Cloudflare.
+type Redirect struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status int    `json:"status,omitempty"` // 301 when omitted
+}
+
+// PageMeta holds the <title> and meta description of a static page.
+type PageMeta struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// SiteConfig holds the identity and layout data of the site, loaded from
+// site.json in the source directory. It is passed to every templ component
+// so that the generator can be reused for another blog without touching Go
+// or templ source.
+type SiteConfig struct {
+	BaseURL   string     `json:"baseURL"`
+	Author    SiteAuthor `json:"author"`
+	Links     []SiteLink `json:"links"`
+	Navbar    []NavLink  `json:"navbar"`
+	Copyright struct {
+		StartYear int `json:"startYear"`
+		EndYear   int `json:"endYear"`
+	} `json:"copyright"`
+	Pages struct {
+		Home            PageMeta `json:"home"`
+		Articles        PageMeta `json:"articles"`
+		Resume          PageMeta `json:"resume"`
+		ResumePrintable PageMeta `json:"resumePrintable"`
+		NotFound        PageMeta `json:"notFound"`
+	} `json:"pages"`
+	ResumePDFURL string   `json:"resumePdfURL"`
+	ArticleTags  []string `json:"articleTags"`
+
+	// AssetInlineLimit is the minified size in bytes up to which page
+	// assets in AssetAuto delivery mode are inlined rather than linked.
+	AssetInlineLimit int `json:"assetInlineLimit"`
+
+	// Redirects are written to _redirects, after the article aliases.
+	Redirects []Redirect `json:"redirects,omitempty"`
+}
+
+// defaultAssetInlineLimit is used when site.json sets no assetInlineLimit.
+const defaultAssetInlineLimit = 4096
+
+// loadSiteConfig reads and unmarshals the site configuration file at
+// filename, rejecting files missing the fields every page relies on.
+func loadSiteConfig(filename string) (SiteConfig, error) {
+	var site SiteConfig
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return site, fmt.Errorf("error reading site config: %w", err)
+	}
+	if err := json.Unmarshal(content, &site); err != nil {
+		return site, fmt.Errorf("error unmarshaling site config %s: %w", filename, err)
+	}
+	if site.Author.Name == "" {
+		return site, fmt.Errorf("site config %s: author.name is required", filename)
+	}
+	if site.AssetInlineLimit == 0 {
+		site.AssetInlineLimit = defaultAssetInlineLimit
+	}
+	if site.BaseURL == "" {
+		return site, fmt.Errorf("site config %s: baseURL is required", filename)
+	}
+	return site, nil
+}
+
+// CopyrightNotice returns the footer copyright line.
+func (s SiteConfig) CopyrightNotice() string {
+	years := fmt.Sprintf("%d", s.Copyright.StartYear)
+	if s.Copyright.EndYear > s.Copyright.StartYear {
+		years = fmt.Sprintf("%d-%d", s.Copyright.StartYear, s.Copyright.EndYear)
+	}
+	return fmt.Sprintf("© %s %s. All Rights Reserved.", years, s.Author.Name)
+}
+
+// FooterLinks returns the links shown in the footer.
+func (s SiteConfig) FooterLinks() []SiteLink {
+	var links []SiteLink
+	for _, link := range s.Links {
+		if link.Footer {
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// ResumeLinks returns the links shown at the bottom of the resume.
+func (s SiteConfig) ResumeLinks() []SiteLink {
+	var links []SiteLink
+	for _, link := range s.Links {
+		if link.Resume {
+			links = append(links, link)
+		}
+	}
+	return links
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/xml"
 	"fmt"
-	"os"
 	"time"
 )
 
@@ -22,9 +21,21 @@ type URL struct {
 	Priority   float64 `xml:"priority,omitempty"`
 }
 
-// generateSitemap writes a sitemap.xml to outputDir covering all static pages
-// and published articles. Draft articles are excluded.
-func generateSitemap(outputDir, baseURL string, allArticles []Article) error {
+// sitemapGenerator adds sitemap.xml to the site.
+type sitemapGenerator struct{}
+
+func (sitemapGenerator) Name() string { return "sitemap" }
+
+func (sitemapGenerator) Pages(site *Site) []Page { return nil }
+
+func (sitemapGenerator) PostProcess(site *Site, out *outputWriter) error {
+	return generateSitemap(out, site.Config, site.Articles)
+}
+
+// generateSitemap stages a sitemap.xml in out covering all static pages and
+// published articles. Draft articles are excluded.
+func generateSitemap(out *outputWriter, config Config, allArticles []Article) error {
+	baseURL := config.BaseURL
 
 	urlset := URLSet{
 		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
@@ -34,21 +45,21 @@ func generateSitemap(outputDir, baseURL string, allArticles []Article) error {
 	now := time.Now().Format("2006-01-02")
 
 	urlset.URLs = append(urlset.URLs, URL{
-		Loc:        baseURL + "/",
+		Loc:        baseURL + config.pageURL("index.html"),
 		LastMod:    now,
 		ChangeFreq: "weekly",
 		Priority:   1.0,
 	})
 
 	urlset.URLs = append(urlset.URLs, URL{
-		Loc:        baseURL + "/articles.html",
+		Loc:        baseURL + config.pageURL("articles.html"),
 		LastMod:    now,
 		ChangeFreq: "weekly",
 		Priority:   0.8,
 	})
 
 	urlset.URLs = append(urlset.URLs, URL{
-		Loc:        baseURL + "/resume.html",
+		Loc:        baseURL + config.pageURL("resume.html"),
 		LastMod:    now,
 		ChangeFreq: "monthly",
 		Priority:   0.6,
@@ -59,7 +70,7 @@ func generateSitemap(outputDir, baseURL string, allArticles []Article) error {
 			continue
 		}
 		urlset.URLs = append(urlset.URLs, URL{
-			Loc:        baseURL + "/" + article.HTMLFilename,
+			Loc:        baseURL + config.pageURL(article.HTMLFilename),
 			LastMod:    article.Date.Format("2006-01-02"),
 			ChangeFreq: "monthly",
 			Priority:   0.9,
@@ -74,11 +85,5 @@ func generateSitemap(outputDir, baseURL string, allArticles []Article) error {
 	xmlHeader := []byte(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
 	fullXML := append(xmlHeader, xmlData...)
 
-	sitemapPath := outputDir + "/sitemap.xml"
-	err = os.WriteFile(sitemapPath, fullXML, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write sitemap file: %v", err)
-	}
-
-	return nil
+	return out.WriteFile("sitemap.xml", fullXML, "")
 }
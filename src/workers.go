@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// forEachParallel calls fn for every index in [0, count) using at most
+// workers goroutines. Callers write results into a slice at index i so that
+// the output order does not depend on scheduling.
+//
+// All calls run to completion; the error returned is the one from the
+// lowest failing index, which is what a sequential loop would have hit
+// first.
+func forEachParallel(count, workers int, fn func(i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, count)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}